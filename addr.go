@@ -0,0 +1,55 @@
+package swim
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// ErrInvalidAddress is returned by Start and Listen when the local address is
+// malformed.
+var ErrInvalidAddress = errors.New("invalid address")
+
+// resolveAddr validates address and resolves it to a UDP address. The empty
+// address denotes all local IP addresses and an automatically chosen port.
+// Errors distinguish malformed addresses, which wrap ErrInvalidAddress, from
+// those that could not be resolved.
+func resolveAddr(address string) (*net.UDPAddr, error) {
+	if address != "" {
+		if err := validateAddr(address); err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidAddress, address, err)
+		}
+	}
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %q: %w", address, err)
+	}
+	return addr, nil
+}
+
+// validateAddr reports the first problem with the form of a host:port
+// address, without resolving it.
+func validateAddr(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		if _, err := netip.ParseAddr(address); err == nil {
+			return errors.New("no port; an IPv6 host must be enclosed in brackets, as in [::1]:7946")
+		}
+		var ae *net.AddrError
+		if errors.As(err, &ae) && strings.HasPrefix(ae.Err, "missing port") {
+			return errors.New(`no port; use "host:port", or "host:" for an automatically chosen port`)
+		}
+		if errors.As(err, &ae) && strings.HasPrefix(ae.Err, "too many colons") {
+			return errors.New("invalid host; an IPv6 host must be enclosed in brackets, as in [::1]:7946")
+		}
+		return fmt.Errorf("invalid host: %v", err)
+	}
+	if strings.HasPrefix(address, "[") {
+		if ip, err := netip.ParseAddr(host); err != nil || !ip.Is6() {
+			return fmt.Errorf("invalid host: %q is not an IPv6 address", host)
+		}
+	}
+	return nil
+}
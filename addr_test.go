@@ -0,0 +1,57 @@
+package swim
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestStartAddress(t *testing.T) {
+	for _, tt := range []struct {
+		address string
+		problem string // empty if valid
+	}{
+		{"", ""},
+		{":0", ""},
+		{"127.0.0.1:", ""},
+		{"[::1]:0", ""},
+		{"localhost", "no port"},
+		{"127.0.0.1", "no port"},
+		{"::1", "no port"},
+		{"[::1]", "no port"},
+		{"::1:0", "brackets"},
+		{"fe80::1:7946:", "brackets"},
+		{"[127.0.0.1]:0", "invalid host"},
+		{"[::1:0", "invalid host"},
+	} {
+		n, err := Start(tt.address)
+		if err == nil {
+			n.Shutdown()
+		}
+		switch {
+		case tt.problem == "" && err != nil:
+			t.Errorf("Start(%q): %v", tt.address, err)
+		case tt.problem != "" && (!errors.Is(err, ErrInvalidAddress) || !strings.Contains(err.Error(), tt.problem)):
+			t.Errorf("Start(%q): got %v, expected %v (%v)", tt.address, err, ErrInvalidAddress, tt.problem)
+		}
+	}
+
+	if _, err := Start("localhost:nonexistent-service"); err == nil || errors.Is(err, ErrInvalidAddress) {
+		t.Errorf("Start with unknown port: got %v, expected resolution error", err)
+	}
+}
+
+func TestStartAddrPort(t *testing.T) {
+	n, err := StartAddrPort(netip.AddrPortFrom(netip.IPv6Loopback(), 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	if addr := n.LocalAddr(); addr.Addr() != netip.IPv6Loopback() || addr.Port() == 0 {
+		t.Errorf("LocalAddr: got %v, expected [::1] with a chosen port", addr)
+	}
+	if _, err := StartAddrPort(netip.AddrPort{}); !errors.Is(err, ErrInvalidAddress) {
+		t.Errorf("StartAddrPort with zero address: got %v, expected %v", err, ErrInvalidAddress)
+	}
+}
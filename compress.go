@@ -0,0 +1,74 @@
+package swim
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"sync"
+)
+
+const (
+	// compressedFlag is the first byte of a compressed packet. It cannot
+	// begin an uncompressed packet, which is a JSON object.
+	compressedFlag = 0x01
+
+	// minCompressLen is the length below which a packet is not worth
+	// compressing.
+	minCompressLen = 256
+)
+
+var errDecompressedTooLong = errors.New("decompressed packet too long")
+
+var flateWriters = sync.Pool{
+	New: func() any {
+		w, err := flate.NewWriter(nil, flate.BestSpeed)
+		if err != nil {
+			panic(err)
+		}
+		return w
+	},
+}
+
+// compress returns b compressed and prefixed with compressedFlag, or b itself
+// if it is too short to be worth compressing or compression would not make it
+// shorter.
+func compress(b []byte) []byte {
+	if len(b) < minCompressLen {
+		return b
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(compressedFlag)
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(b); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	if buf.Len() >= len(b) {
+		return b
+	}
+	return buf.Bytes()
+}
+
+// decompress returns the uncompressed form of a received packet b, which may
+// or may not be compressed. It returns an error if b decompresses to more
+// than the maximum size of a UDP datagram.
+func decompress(b []byte) ([]byte, error) {
+	if len(b) == 0 || b[0] != compressedFlag {
+		return b, nil
+	}
+	r := flate.NewReader(bytes.NewReader(b[1:]))
+	defer r.Close()
+	d, err := io.ReadAll(io.LimitReader(r, maxReceiveBufferSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(d) > maxReceiveBufferSize {
+		return nil, errDecompressedTooLong
+	}
+	return d, nil
+}
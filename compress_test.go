@@ -0,0 +1,76 @@
+package swim
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"kr.dev/diff"
+)
+
+func TestCompress(t *testing.T) {
+	for _, b := range [][]byte{
+		[]byte(`{"SrcID":"ABC"}`),
+		bytes.Repeat([]byte(`{"Type":0,"NodeID":"ABC"}`), 100),
+	} {
+		c := compress(b)
+		if len(c) > len(b) {
+			t.Errorf("compress(%v bytes): got %v bytes", len(b), len(c))
+		}
+		d, err := decompress(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(d, b) {
+			t.Errorf("decompress(compress(%q)): got %q", b, d)
+		}
+	}
+}
+
+func TestDecompressTooLong(t *testing.T) {
+	c := compress(make([]byte, maxReceiveBufferSize+1))
+	if _, err := decompress(c); !errors.Is(err, errDecompressedTooLong) {
+		t.Errorf("decompress: got %v, expected %v", err, errDecompressedTooLong)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	n0, err := Start("", WithCompression())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n0.Shutdown()
+	n1, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n1.Shutdown()
+	memos := make(chan string)
+	n1.OnMemo(func(_ string, _ netip.AddrPort, memo []byte) { memos <- string(memo) })
+
+	memo := bytes.Repeat([]byte("Hello, SWIM! "), 30)
+	n0.PostMemo(memo)
+	n0.Join(n1.localAddrPort())
+	diff.Test(t, t.Errorf, <-memos, string(memo))
+}
+
+func BenchmarkCompress(b *testing.B) {
+	for _, nMsgs := range []int{1, 6, 20} {
+		var msgs []*message
+		for i := 0; i < nMsgs; i++ {
+			msgs = append(msgs, &message{Type: alive, NodeID: randID(), Incarnation: i})
+		}
+		n := &Node{id: randID()}
+		raw := n.encode(packet{Type: ping, Msgs: msgs})
+		b.Run(fmt.Sprintf("%vmsgs", nMsgs), func(b *testing.B) {
+			var c []byte
+			for i := 0; i < b.N; i++ {
+				c = compress(raw)
+			}
+			b.ReportMetric(float64(len(raw)), "raw-bytes")
+			b.ReportMetric(float64(len(raw)-len(c)), "saved-bytes")
+		})
+	}
+}
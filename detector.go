@@ -0,0 +1,146 @@
+package swim
+
+import (
+	"math"
+	"time"
+)
+
+// A FailureDetector determines how aggressively a Node declares unresponsive
+// peers failed. A Node consults its FailureDetector while holding its lock, so
+// the methods need not be safe for concurrent use, but a FailureDetector that
+// keeps state must not be shared between Nodes.
+type FailureDetector interface {
+	// SuspicionTimeout returns the number of protocol periods to wait
+	// before declaring a suspected peer failed, given the number of nodes in
+	// the network, including the local Node, and the number of other peers
+	// that have independently reported the same suspicion. The Node scales
+	// the result by its suspicion jitter.
+	SuspicionTimeout(size, confirmations int) int
+
+	// IndirectProbes returns the number of peers to ask to probe a ping
+	// target that fails to acknowledge a direct probe, given the number of
+	// nodes in the network, including the local Node.
+	IndirectProbes(size int) int
+
+	// ObserveProbe is called when each probe of a ping target concludes,
+	// with a value reporting whether the target acknowledged it, directly
+	// or indirectly.
+	ObserveProbe(acked bool)
+}
+
+// swimDetector is the FailureDetector a Node uses by default. It waits the
+// dissemination timescale, 2*log(n) periods rounded up, before declaring a
+// suspect failed, regardless of confirmations, and requests two indirect
+// probes.
+type swimDetector struct{}
+
+func (swimDetector) SuspicionTimeout(size, _ int) int { return logTimeout(size) }
+
+func (swimDetector) IndirectProbes(int) int { return 2 }
+
+func (swimDetector) ObserveProbe(bool) {}
+
+// logTimeout returns 2*log(n) rounded up, where n is the size of the network.
+func logTimeout(size int) int {
+	const λ = 2 // at least 1, like the multiplier set by WithRetransmitMultiplier
+	return int(math.Ceil(λ * math.Log(float64(size))))
+}
+
+// Lifeguard is a FailureDetector based on the Lifeguard extensions to SWIM,
+// which reduce the false positives caused by slow or overloaded nodes.
+//
+// A suspected peer is given a long suspicion timeout, which shrinks toward
+// the dissemination timescale, 2*log(n) periods rounded up, as other peers
+// independently confirm the suspicion. A peer that is merely slow to respond
+// to one member is therefore given time to refute suspicion, while one that
+// has truly failed, and is suspected by many members, is declared failed
+// almost as quickly as with the default detector.
+//
+// Lifeguard also tracks the Node's own health: each probe that goes
+// unacknowledged raises a health multiplier, and each acknowledged probe
+// lowers it. When the Node is itself the likely cause of missed
+// acknowledgments, because it is overloaded or its network is degraded, the
+// multiplier lengthens its suspicion timeouts so that it does not declare
+// healthy peers failed.
+//
+// The zero value is ready to use with the default settings. A Lifeguard keeps
+// state, and must not be shared between Nodes.
+type Lifeguard struct {
+	// MaxTimeoutMultiplier is the ratio of the longest suspicion timeout,
+	// with no confirmations, to the shortest. The default is 6.
+	MaxTimeoutMultiplier int
+
+	// Confirmations is the number of confirmations at which the suspicion
+	// timeout reaches its minimum. The default is 3.
+	Confirmations int
+
+	// MaxHealth is the maximum value of the health multiplier. The default
+	// is 8.
+	MaxHealth int
+
+	// IndirectChecks is the number of peers to ask to probe an unresponsive
+	// ping target. The default is 3.
+	IndirectChecks int
+
+	health int // 0 when healthy
+}
+
+// SuspicionTimeout returns a timeout between the dissemination timescale and
+// MaxTimeoutMultiplier times that, decreasing logarithmically with the number
+// of confirmations, then scales it by one more than the health multiplier.
+func (l *Lifeguard) SuspicionTimeout(size, confirmations int) int {
+	min := logTimeout(size)
+	max := min * orDefault(l.MaxTimeoutMultiplier, 6)
+	k := orDefault(l.Confirmations, 3)
+	if confirmations > k {
+		confirmations = k
+	}
+	frac := math.Log(float64(confirmations+1)) / math.Log(float64(k+1))
+	t := max - int(math.Floor(frac*float64(max-min)))
+	return t * (l.health + 1)
+}
+
+// IndirectProbes returns IndirectChecks.
+func (l *Lifeguard) IndirectProbes(int) int {
+	return orDefault(l.IndirectChecks, 3)
+}
+
+// ObserveProbe updates the health multiplier.
+func (l *Lifeguard) ObserveProbe(acked bool) {
+	switch {
+	case acked && l.health > 0:
+		l.health--
+	case !acked && l.health < orDefault(l.MaxHealth, 8):
+		l.health++
+	}
+}
+
+// orDefault returns v if it is positive, or else def.
+func orDefault(v, def int) int {
+	if v > 0 {
+		return v
+	}
+	return def
+}
+
+// PeriodsDuration returns the shortest and longest times that the given
+// number of protocol periods can last. A Node begins a period every 0.9 to
+// 1.1 seconds, chosen at random, so quantities that a FailureDetector
+// expresses in periods correspond to a range of wall-clock durations.
+func PeriodsDuration(periods int) (shortest, longest time.Duration) {
+	return time.Duration(periods) * minTickPeriod, time.Duration(periods) * maxTickPeriod
+}
+
+// SuspicionTimeoutDuration returns the range of time that n currently waits
+// before declaring a suspected peer failed, if no other member confirms the
+// suspicion. The timeout depends on the size of the network and n's
+// FailureDetector, and includes the jitter set by WithSuspicionJitter. Both
+// durations are 0 if suspicion is disabled.
+func (n *Node) SuspicionTimeoutDuration() (shortest, longest time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.fsm.suspicion {
+		return 0, 0
+	}
+	return PeriodsDuration(n.fsm.suspicionTimeout())
+}
@@ -0,0 +1,143 @@
+package swim
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLifeguard(t *testing.T) {
+	var l Lifeguard
+	// The minimum timeout for 100 nodes is ⌈2 ln 100⌉ = 10
+	for _, tt := range []struct {
+		confirmations int
+		want          int
+	}{
+		{0, 60},
+		{1, 35},
+		{2, 21},
+		{3, 10},
+		{10, 10},
+	} {
+		if got := l.SuspicionTimeout(100, tt.confirmations); got != tt.want {
+			t.Errorf("SuspicionTimeout(100, %v): got %v, expected %v", tt.confirmations, got, tt.want)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		l.ObserveProbe(false)
+	}
+	if got := l.SuspicionTimeout(100, 3); got != 90 {
+		t.Errorf("SuspicionTimeout at maximum health multiplier: got %v, expected 90", got)
+	}
+	for i := 0; i < 8; i++ {
+		l.ObserveProbe(true)
+	}
+	if got := l.SuspicionTimeout(100, 3); got != 10 {
+		t.Errorf("SuspicionTimeout after recovery: got %v, expected 10", got)
+	}
+}
+
+func TestSuspicionConfirmations(t *testing.T) {
+	s := newTestStateMachine()
+	s.detector = new(Lifeguard)
+	s.receive(packet{
+		Type:     ping,
+		remoteID: "abc",
+		Msgs: []*message{
+			{Type: alive, NodeID: "abc"},
+			{Type: alive, NodeID: "def"},
+			{Type: alive, NodeID: "ghi"},
+			{Type: suspected, NodeID: "xyz", Origin: "abc"},
+		},
+	})
+	base := s.suspectTimeout("xyz")
+	// The originator of s's suspicion, s itself, and the suspect do not
+	// confirm it
+	for _, origin := range []id{"abc", "def", "def", "ghi", "jkl", s.id, "xyz"} {
+		s.receive(packet{
+			Type:     ping,
+			remoteID: "abc",
+			Msgs:     []*message{{Type: suspected, NodeID: "xyz", Origin: origin}},
+		})
+	}
+	if n := len(s.confirms["xyz"]); n != 3 {
+		t.Errorf("confirmations: got %v, expected 3", n)
+	}
+	if got := s.suspectTimeout("xyz"); got >= base {
+		t.Errorf("timeout with confirmations: got %v, expected less than %v", got, base)
+	}
+	s.receive(packet{
+		Type:     ping,
+		remoteID: "xyz",
+		Msgs:     []*message{{Type: alive, NodeID: "xyz", Incarnation: 1}},
+	})
+	if _, ok := s.confirms["xyz"]; ok {
+		t.Error("confirmations not cleared by refutation")
+	}
+}
+
+func TestSuspicionRelays(t *testing.T) {
+	s := newTestStateMachine()
+	s.detector = new(Lifeguard)
+	s.receive(packet{
+		Type:     ping,
+		remoteID: "abc",
+		Msgs: []*message{
+			{Type: alive, NodeID: "abc"},
+			{Type: alive, NodeID: "def"},
+			{Type: alive, NodeID: "ghi"},
+			{Type: suspected, NodeID: "xyz", Origin: "abc"},
+		},
+	})
+	base := s.suspectTimeout("xyz")
+	// Members relaying a single suspicion, or suspicion of unknown origin,
+	// do not confirm it
+	for _, from := range []id{"abc", "def", "ghi"} {
+		for _, origin := range []id{"abc", ""} {
+			s.receive(packet{
+				Type:     ping,
+				remoteID: from,
+				Msgs:     []*message{{Type: suspected, NodeID: "xyz", Origin: origin}},
+			})
+		}
+	}
+	if n := len(s.confirms["xyz"]); n != 0 {
+		t.Errorf("confirmations: got %v, expected 0", n)
+	}
+	if got := s.suspectTimeout("xyz"); got != base {
+		t.Errorf("timeout after relays: got %v, expected %v", got, base)
+	}
+}
+
+func TestSuspicionTimeoutDuration(t *testing.T) {
+	if lo, hi := PeriodsDuration(3); lo != 2700*time.Millisecond || hi != 3300*time.Millisecond {
+		t.Errorf("PeriodsDuration(3): got %v, %v; expected 2.7s, 3.3s", lo, hi)
+	}
+
+	n, err := Start("", WithSuspicionJitter(0), WithFailureDetector(&Lifeguard{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	var msgs []*message
+	for i := 1; i < 100; i++ {
+		msgs = append(msgs, &message{Type: alive, NodeID: id(fmt.Sprint(i))})
+	}
+	n.receive(packet{Type: gossip, remoteID: "1", Msgs: msgs})
+	// Lifeguard's timeout for an unconfirmed suspicion in a network of 100
+	// is 6 times the minimum of 10 periods
+	wantLo, wantHi := PeriodsDuration(60)
+	if lo, hi := n.SuspicionTimeoutDuration(); lo != wantLo || hi != wantHi {
+		t.Errorf("SuspicionTimeoutDuration: got %v, %v; expected %v, %v", lo, hi, wantLo, wantHi)
+	}
+
+	n, err = Start("", WithSuspicion(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	if lo, hi := n.SuspicionTimeoutDuration(); lo != 0 || hi != 0 {
+		t.Errorf("SuspicionTimeoutDuration without suspicion: got %v, %v; expected 0, 0", lo, hi)
+	}
+}
@@ -0,0 +1,80 @@
+package swim
+
+import (
+	"encoding/json"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// exportVersion is the version of the document that ExportJSON produces. It
+// changes only if a change to the document could break existing consumers;
+// new fields may be added without changing it.
+const exportVersion = 1
+
+// A membershipExport is the document that ExportJSON produces.
+type membershipExport struct {
+	Version    int              `json:"version"`
+	Time       time.Time        `json:"time"`
+	Self       string           `json:"self"`
+	Generation uint64           `json:"generation"`
+	Members    []exportedMember `json:"members"`
+}
+
+// An exportedMember describes a member in a membershipExport.
+type exportedMember struct {
+	ID          string         `json:"id"`
+	Addr        netip.AddrPort `json:"addr"`
+	Incarnation int            `json:"incarnation"`
+	Status      string         `json:"status"` // "alive" or "suspect"
+	Self        bool           `json:"self,omitempty"`
+}
+
+// ExportJSON returns a JSON document describing the membership of the network
+// known to n, for consumption by service discovery systems, dashboards, and
+// other tools. Unlike the values returned by Members, the document reports
+// which peers n suspects of having failed. It has the form
+//
+//	{
+//		"version": 1,
+//		"time": "2006-01-02T15:04:05.999999999Z",
+//		"self": "<n's ID>",
+//		"generation": 7,
+//		"members": [
+//			{"id": "...", "addr": "192.0.2.1:7946", "incarnation": 0, "status": "alive", "self": true},
+//			{"id": "...", "addr": "192.0.2.2:7946", "incarnation": 2, "status": "suspect"}
+//		]
+//	}
+//
+// where time is the UTC time at which the document was produced, generation is
+// the membership's Generation, and members are sorted by ID and include n
+// itself unless it is an observer. The version changes only if the document
+// changes in a way that could break existing consumers; fields may be added
+// without changing it.
+func (n *Node) ExportJSON() ([]byte, error) {
+	n.mu.Lock()
+	doc := membershipExport{
+		Version:    exportVersion,
+		Time:       time.Now().UTC(),
+		Self:       string(n.fsm.id),
+		Generation: n.fsm.generation,
+		Members:    make([]exportedMember, 0, len(n.fsm.members)+1),
+	}
+	n.rangeMembers(func(m Member) bool {
+		status := "alive"
+		if n.fsm.isSuspect(id(m.ID)) {
+			status = "suspect"
+		}
+		doc.Members = append(doc.Members, exportedMember{
+			ID:          m.ID,
+			Addr:        m.Addr,
+			Incarnation: m.Incarnation,
+			Status:      status,
+			Self:        m.ID == doc.Self,
+		})
+		return true
+	})
+	n.mu.Unlock()
+	sort.Slice(doc.Members, func(i, j int) bool { return doc.Members[i].ID < doc.Members[j].ID })
+	return json.Marshal(doc)
+}
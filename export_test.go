@@ -0,0 +1,67 @@
+package swim
+
+import (
+	"encoding/json"
+	"net/netip"
+	"testing"
+	"time"
+
+	"kr.dev/diff"
+)
+
+func TestExportJSON(t *testing.T) {
+	n, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	n.mu.Lock()
+	n.fsm.id = "MMM"
+	n.fsm.incarnation = 1
+	n.mu.Unlock()
+	addrs := []netip.AddrPort{
+		netip.MustParseAddrPort("127.0.0.1:1000"),
+		netip.MustParseAddrPort("[::1]:2000"),
+	}
+	n.receive(packet{
+		Type:     ping,
+		remoteID: "BBB",
+		Msgs: []*message{
+			{Type: alive, NodeID: "BBB", Addr: addrs[0], Incarnation: 2},
+			{Type: alive, NodeID: "AAA", Addr: addrs[1], Incarnation: 3},
+		},
+	})
+	n.receive(packet{
+		Type:     ping,
+		remoteID: "BBB",
+		Msgs:     []*message{{Type: suspected, NodeID: "AAA", Incarnation: 3}},
+	})
+
+	before := time.Now()
+	b, err := n.ExportJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("unmarshal %s: %v", b, err)
+	}
+	ts, err := time.Parse(time.RFC3339Nano, doc["time"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Before(before.Truncate(time.Second)) || ts.After(time.Now()) {
+		t.Errorf("time: got %v, expected about %v", ts, before)
+	}
+	delete(doc, "time")
+	diff.Test(t, t.Errorf, doc, map[string]any{
+		"version":    1.0,
+		"self":       "MMM",
+		"generation": float64(n.Generation()),
+		"members": []any{
+			map[string]any{"id": "AAA", "addr": "[::1]:2000", "incarnation": 3.0, "status": "suspect"},
+			map[string]any{"id": "BBB", "addr": "127.0.0.1:1000", "incarnation": 2.0, "status": "alive"},
+			map[string]any{"id": "MMM", "addr": n.LocalAddr().String(), "incarnation": 1.0, "status": "alive", "self": true},
+		},
+	})
+}
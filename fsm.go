@@ -2,7 +2,10 @@ package swim
 
 import (
 	"math"
+	"math/rand"
 	"net/netip"
+	"sort"
+	"time"
 
 	"github.com/dkmccandless/swim/internal/roundrobinrandom"
 	"github.com/dkmccandless/swim/internal/rpq"
@@ -12,27 +15,73 @@ import (
 type stateMachine struct {
 	id          id
 	incarnation int
+	addr        netip.AddrPort // address s announces, if set; else peers use the source of its packets
 
 	members  map[id]*profile
-	suspects map[id]int  // number of periods under suspicion
-	removed  map[id]bool // removed ids // TODO: expire old entries by timestamp
+	suspects map[id]int         // number of periods under suspicion
+	confirms map[id]map[id]bool // members that confirmed each suspicion
+	removed  removedSet         // removed ids and when they were removed // TODO: expire old entries
+
+	generation uint64 // number of changes to members
 
 	order roundrobinrandom.Order[id]
 
 	msgQueue  *rpq.Queue[id, *message]
 	memoQueue *rpq.Queue[id, *message]
 	seenMemos map[id]bool
+	memoSeq   uint64             // sequence number of s's latest memo
+	memoSends uint64             // number of times memos have been sent
+	memoClock uint64             // number of memos queued
+	memoBufs  map[id]*memoBuffer // memos awaiting in-order delivery
+
+	// newestFirst is whether to send the most recently queued of the
+	// least-sent memos first
+	newestFirst bool
+
+	probesPerPeriod int // ping targets to probe each period
+	retransmitMult  int // multiple of log(n) times to send each message
+	smallSize       int // network size below which smallMisses applies, or 0
+	smallMisses     int // consecutive misses needed to suspect a member of a small network
 
-	pingTarget id
-	gotAck     bool
-	pingReqs   map[id]id
+	period    int                    // number of protocol periods begun
+	probeSeq  uint64                 // sequence number of the current period's probe
+	probes    []*probe               // probes of the current period's ping targets
+	concluded bool                   // whether the current period's probes have concluded
+	pingReqs  map[pingRequest]uint64 // ping requests received this period, with the requesters' probe sequence numbers
 
-	nPingReqs int
-	maxMsgs   int
+	partitions map[id]*partitionEvidence
+
+	detector       FailureDetector // nil for swimDetector
+	maxMsgs        int
+	suspicionScale float64                       // multiplier applied to the suspicion timeout
+	hysteresis     int                           // probes needed to change a flapping member's status
+	suspicion      bool                          // whether to suspect expired ping targets before failing them
+	suspectMemos   bool                          // whether to deliver memos from suspected members
+	selfMemos      bool                          // whether to deliver the memos s posts to s
+	gossipFanout   int                           // members besides the ping targets to gossip to each period
+	maxIdle        int                           // periods without a direct ack before suspicion, or 0
+	memoBudget     int                           // times to send each memo, or 0 for the dissemination factor
+	observer       bool                          // whether s only observes the network without joining it
+	maxMembers     int                           // maximum size of the network, or 0 for no limit
+	joinFilter     func(id, netip.AddrPort) bool // whether to admit a new member, or nil to admit all
+	rejectedJoins  uint64                        // number of messages about new members ignored
+	quarantine     time.Duration                 // time before a removed id may announce itself again
+	trusted        map[netip.AddrPort]bool       // addresses of non-members whose packets s accepts, or nil to accept all
+	strangers      uint64                        // number of packets ignored from non-members
+	seeds          []netip.AddrPort              // addresses to rejoin through if s loses all its members
+	rejoinGrace    int                           // periods to wait before rejoining through the seeds
+	joined         bool                          // whether s has had a member
+	isolated       bool                          // whether s has lost all its members and is rejoining
+	nextRejoin     int                           // period of s's next attempt to rejoin while isolated
+	rejoinInterval int                           // periods between s's attempts to rejoin
+	draining       bool                          // whether s has stopped probing in preparation for leaving
+	left           bool                          // whether s has announced its departure
 
 	handleJoin func(id, netip.AddrPort)
 	handleMemo func(id, netip.AddrPort, []byte)
-	handleFail func(id)
+	handleFail func(id, FailReason)
+
+	now func() time.Time
 }
 
 // A packetType describes the meaning of a packet.
@@ -42,6 +91,7 @@ const (
 	ping packetType = iota
 	pingReq
 	ack
+	gossip // carries messages only, and requires no response
 )
 
 // A packet represents a network packet.
@@ -54,9 +104,32 @@ type packet struct {
 	TargetID   id             `json:",omitempty"`
 	TargetAddr netip.AddrPort `json:",omitempty"`
 
+	// ProbeSeq identifies the probe to which a ping or ping request belongs,
+	// and is echoed by the corresponding ack. It is 0 in packets from nodes
+	// that do not number their probes.
+	ProbeSeq uint64 `json:",omitempty"`
+
+	// Observer reports that the sender is an observer, which asks non-members
+	// to acknowledge its pings with a sample of their members.
+	Observer bool `json:",omitempty"`
+
 	Msgs []*message `json:",omitempty"`
 }
 
+// A pingRequest is a request to ping a target on behalf of another member.
+type pingRequest struct {
+	requester id
+	target    id
+}
+
+// A probe is the probe of a ping target during a protocol period.
+type probe struct {
+	target    id
+	gotAck    bool
+	directAck bool        // whether the target acked directly
+	relays    map[id]bool // members that relayed acks from the target
+}
+
 // A msgType describes the meaning of a message.
 type msgType byte
 
@@ -76,6 +149,15 @@ type message struct {
 	// for memo
 	MemoID id     `json:",omitempty"`
 	Body   []byte `json:",omitempty"`
+	Seq    uint64 `json:",omitempty"` // position among the sender's memos
+
+	// for failed
+	Reason FailReason `json:",omitempty"`
+
+	// for suspected
+	Origin id `json:",omitempty"` // the member that first suspected NodeID, if known
+
+	queued uint64 // when the memo was queued locally, in order of queueing
 }
 
 // A profile contains an ID's membership information.
@@ -83,120 +165,620 @@ type profile struct {
 	incarnation int
 	contacted   bool
 	addr        netip.AddrPort
+
+	acks     int  // consecutive acknowledged probes
+	misses   int  // consecutive unacknowledged probes
+	flapping bool // refuted suspicion since its last run of hysteresis acks
+
+	suspectedBy id // originator of the current suspicion, if known
+
+	stats PeerStats // probes of the member since its incarnation last changed
+
+	lastSeen time.Time // when an ack or alive message was last received
+	lastAck  int       // period of the last direct ack, or of joining or idle suspicion
+}
+
+// A memoBuffer holds memos received from a member ahead of their turn for
+// delivery.
+type memoBuffer struct {
+	next    uint64 // sequence number of the next memo to deliver
+	pending map[uint64]*message
+	since   int // period since which delivery has been blocked
 }
 
+// memoReorderPeriods is the number of protocol periods a memo is held awaiting
+// the delivery of the memos that precede it, after which the missing memos are
+// given up for lost.
+const memoReorderPeriods = 2
+
+// partitionEvidence records the probes of a member that have been
+// acknowledged only indirectly since it last acknowledged a probe directly.
+type partitionEvidence struct {
+	probes     int
+	lastPeriod int
+	relays     map[id]bool
+}
+
+const (
+	// minPartitionProbes is the number of probes that must succeed only
+	// indirectly before a member is reported as partitioned.
+	minPartitionProbes = 2
+
+	// partitionRounds is the number of rounds of probes after which
+	// partition evidence expires if it does not recur.
+	partitionRounds = 3
+)
+
 // newStateMachine initializes a new stateMachine emitting membership
 // information and memos via the provided handler callbacks.
 func newStateMachine(
 	handleJoin func(id, netip.AddrPort),
 	handleMemo func(id, netip.AddrPort, []byte),
-	handleFail func(id),
+	handleFail func(id, FailReason),
 ) *stateMachine {
 	s := &stateMachine{
 		id: randID(),
 
 		members:  make(map[id]*profile),
 		suspects: make(map[id]int),
-		removed:  make(map[id]bool),
+		confirms: make(map[id]map[id]bool),
+		removed:  make(removedMap),
 
 		seenMemos: make(map[id]bool),
+		memoBufs:  make(map[id]*memoBuffer),
+
+		partitions: make(map[id]*partitionEvidence),
+
+		pingReqs: make(map[pingRequest]uint64),
+		maxMsgs:  6, // TODO: revisit guaranteed MTU constraint
+
+		probesPerPeriod: 1,
+		retransmitMult:  2,
 
-		pingReqs:  make(map[id]id),
-		nPingReqs: 2, // TODO: scale according to permissible false positive probability
-		maxMsgs:   6, // TODO: revisit guaranteed MTU constraint
+		suspicionScale: 1,
+		hysteresis:     1,
+		suspicion:      true,
+		suspectMemos:   true,
+		quarantine:     defaultQuarantine,
 
 		handleJoin: handleJoin,
 		handleMemo: handleMemo,
 		handleFail: handleFail,
+
+		now: time.Now,
 	}
 
-	s.msgQueue = rpq.New[id, *message](s.disseminationFactor)
-	s.memoQueue = rpq.New[id, *message](s.disseminationFactor)
+	s.msgQueue = rpq.New[id, *message](s.disseminationFactor, isMoreUrgent)
+	s.memoQueue = rpq.New[id, *message](s.memoQuota, s.memoLess)
+
+	// Seeding the probe order from the id decorrelates the orders in
+	// which members probe one another
+	s.order.SetRand(rand.New(rand.NewSource(s.id.seed())))
 	return s
 }
 
 // tick begins a new protocol period and returns a ping, as well as packets to
-// notify any members declared suspected or failed.
+// notify any members declared suspected or failed and to gossip to up to
+// gossipFanout other members.
 func (s *stateMachine) tick() []packet {
 	var ps []packet
-	for id := range s.suspects {
-		if s.suspects[id]++; s.suspects[id] >= s.disseminationFactor() {
+	for _, id := range sortedIDs(s.suspects) {
+		if s.suspects[id]++; s.suspects[id] >= s.suspectTimeout(id) {
 			// Suspicion timeout
-			m := s.failedMessage(id)
-			s.msgQueue.Upsert(id, m)
-			ps = append(ps, s.makeMessagePing(m))
-			s.remove(id)
+			if !s.observer {
+				m := s.failedMessage(id)
+				s.enqueue(m)
+				ps = append(ps, s.makeMessagePing(m))
+			}
+			s.remove(id, Failed)
+		}
+	}
+	ps = append(ps, s.expire()...)
+	if !s.observer && !s.draining {
+		ps = append(ps, s.suspectIdle()...)
+	}
+	ps = append(ps, s.rejoin()...)
+	s.period++
+	s.releaseLateMemos()
+	s.concluded = false
+	s.pingReqs = map[pingRequest]uint64{}
+	s.probeSeq++
+	k := s.probesPerPeriod
+	if k > len(s.members) {
+		k = len(s.members)
+	}
+	// A round may end partway through the period, and a target from the
+	// last round may come up again early in the next; take each only once
+	var targets []id
+	for i := 0; len(targets) < k && i < 2*k; i++ {
+		if target := s.order.Next(); !containsID(targets, target) {
+			targets = append(targets, target)
 		}
 	}
-	if id := s.pingTarget; !s.gotAck && s.isMember(id) {
-		// Expired ping target
-		if !s.isSuspect(id) {
-			s.suspects[id] = 0
+	s.setProbes(targets...)
+	if len(s.probes) == 0 {
+		return ps
+	}
+	for _, pr := range s.probes {
+		s.members[pr.target].stats.PingsSent++
+		ps = append(ps, s.makePing(pr.target))
+	}
+	return append(ps, s.gossip()...)
+}
+
+// setProbes begins the current period's probes of targets.
+func (s *stateMachine) setProbes(targets ...id) {
+	s.probes = nil
+	for _, target := range targets {
+		if target != "" {
+			s.probes = append(s.probes, &probe{target: target, relays: make(map[id]bool)})
 		}
+	}
+}
+
+// containsID reports whether ids contains id.
+func containsID(ids []id, id id) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
+
+// probeOf returns the current period's probe of target, or nil if target is
+// not a ping target.
+func (s *stateMachine) probeOf(target id) *probe {
+	for _, pr := range s.probes {
+		if pr.target == target {
+			return pr
+		}
+	}
+	return nil
+}
+
+// maxRejoinInterval is the maximum number of protocol periods between an
+// isolated state machine's attempts to rejoin through its seeds.
+const maxRejoinInterval = 32
+
+// rejoin returns join requests to each of s's seeds if s is isolated, having
+// removed all of its members, for example after a network partition. s first
+// waits rejoinGrace periods, then attempts to rejoin at intervals that double
+// up to maxRejoinInterval periods. Once s has a member again, it is no longer
+// isolated.
+func (s *stateMachine) rejoin() []packet {
+	if len(s.members) > 0 {
+		s.isolated = false
+		return nil
+	}
+	if len(s.seeds) == 0 || !s.joined || s.draining || s.left {
+		return nil
+	}
+	if !s.isolated {
+		// The queued news of the members' failures is stale by the time
+		// s rejoins, and a member that receives news of its own failure
+		// stops participating in the protocol. Likewise, s forgets the
+		// members it removed, so that it can learn of those that survived
+		// from its seeds.
+		s.isolated = true
+		s.msgQueue.Clear()
+		s.removed.reset()
+		s.nextRejoin = s.period + s.rejoinGrace
+		s.rejoinInterval = 1
+	}
+	if s.period < s.nextRejoin {
+		return nil
+	}
+	s.nextRejoin = s.period + s.rejoinInterval
+	if s.rejoinInterval *= 2; s.rejoinInterval > maxRejoinInterval {
+		s.rejoinInterval = maxRejoinInterval
+	}
+	var ps []packet
+	for _, addr := range s.seeds {
+		s.trust(addr)
+		ps = append(ps, s.makeJoin(addr))
+	}
+	return ps
+}
+
+// gossip returns packets carrying messages awaiting dissemination to up to
+// gossipFanout random members other than the ping targets. It stops early if
+// the message queues empty, as makePacket respects their quotas.
+func (s *stateMachine) gossip() []packet {
+	if s.gossipFanout == 0 {
+		return nil
+	}
+	var ps []packet
+	for _, id := range s.order.IndependentSampleFunc(s.gossipFanout, func(id id) bool { return s.probeOf(id) != nil }) {
+		if s.msgQueue.Len() == 0 && s.memoQueue.Len() == 0 {
+			break
+		}
+		ps = append(ps, s.makePacket(gossip, id, "", netip.AddrPort{}))
+	}
+	return ps
+}
+
+// expire concludes the current protocol period's probes of the ping targets,
+// if they have not already concluded, and returns packets announcing any
+// resulting suspicion. Acks that arrive after the probes conclude are
+// disregarded.
+func (s *stateMachine) expire() []packet {
+	if s.observer || s.draining || s.concluded {
+		return nil
+	}
+	s.concluded = true
+	s.recordPartitionEvidence()
+	var ps []packet
+	for _, pr := range s.probes {
+		ps = append(ps, s.concludeProbe(pr)...)
+	}
+	return ps
+}
+
+// concludeProbe records the outcome of the current protocol period's probe pr
+// and returns packets announcing any resulting suspicion, or failure if
+// suspicion is disabled.
+//
+// To dampen flapping, a member that has refuted suspicion is not suspected
+// again until it misses hysteresis consecutive probes, unless it has since
+// acknowledged hysteresis consecutive probes.
+func (s *stateMachine) concludeProbe(pr *probe) []packet {
+	id := pr.target
+	p, ok := s.members[id]
+	if !ok {
+		return nil
+	}
+	s.failureDetector().ObserveProbe(pr.gotAck)
+	if pr.gotAck {
+		p.misses = 0
+		if p.acks++; p.acks >= s.hysteresis {
+			p.flapping = false
+		}
+		return nil
+	}
+	p.acks = 0
+	if p.misses++; p.flapping && p.misses < s.hysteresis {
+		return nil
+	}
+	if len(s.members)+1 < s.smallSize && p.misses < s.smallMisses {
+		// Too few members to probe indirectly reliably, so a single
+		// lost packet does not suffice
+		return nil
+	}
+	// Expired ping target
+	if !s.suspicion {
+		m := s.failedMessage(id)
+		s.enqueue(m)
+		s.remove(id, Failed)
+		return []packet{s.makeMessagePing(m)}
+	}
+	if !s.isSuspect(id) {
+		s.suspects[id] = 0
+		s.members[id].suspectedBy = s.id
+	}
+	// Report the suspicion as s's own, so that members that already
+	// suspect id count it as a confirmation
+	m := s.suspectedMessage(id)
+	m.Origin = s.id
+	s.enqueue(m)
+	return []packet{s.makeMessagePing(m)}
+}
+
+// suspectIdle suspects the members that have not acknowledged a packet
+// directly for maxIdle periods, if maxIdle is positive, and returns packets
+// announcing the suspicion. This catches members that remain reachable only
+// through other members, which indirect probes would otherwise keep in the
+// network indefinitely. A member that refutes idle suspicion is not suspected
+// for being idle again for another maxIdle periods.
+func (s *stateMachine) suspectIdle() []packet {
+	if s.maxIdle <= 0 {
+		return nil
+	}
+	var ps []packet
+	for _, id := range sortedIDs(s.members) {
+		p := s.members[id]
+		if s.isSuspect(id) || s.period-p.lastAck < s.maxIdle {
+			continue
+		}
+		p.lastAck = s.period
+		p.suspectedBy = s.id
+		s.suspects[id] = 0
 		m := s.suspectedMessage(id)
-		s.msgQueue.Upsert(id, m)
+		s.enqueue(m)
 		ps = append(ps, s.makeMessagePing(m))
 	}
-	s.gotAck = false
-	s.pingReqs = map[id]id{}
-	s.pingTarget = s.order.Next()
-	if s.pingTarget == "" {
-		return ps
+	return ps
+}
+
+// recordPartitionEvidence updates the partition evidence for the current
+// protocol period's ping targets and expires evidence that has not recurred
+// within partitionRounds rounds of probes.
+//
+// A ping target that acknowledges only via other members, after the direct
+// probe has timed out, may be separated from s by an asymmetric partition
+// that the indirect probes otherwise mask.
+func (s *stateMachine) recordPartitionEvidence() {
+	for _, pr := range s.probes {
+		target := pr.target
+		switch {
+		case !s.isMember(target):
+		case pr.directAck:
+			delete(s.partitions, target)
+		case len(pr.relays) > 0:
+			e, ok := s.partitions[target]
+			if !ok {
+				e = &partitionEvidence{relays: make(map[id]bool)}
+				s.partitions[target] = e
+			}
+			e.probes++
+			e.lastPeriod = s.period
+			for r := range pr.relays {
+				e.relays[r] = true
+			}
+		}
+	}
+	maxAge := partitionRounds * (len(s.members) + 1)
+	for id, e := range s.partitions {
+		if s.period-e.lastPeriod > maxAge {
+			delete(s.partitions, id)
+		}
 	}
-	return append(ps, s.makePing(s.pingTarget))
 }
 
-// timeout produces ping requests if an ack has not been received from the
-// ping target, or else nil.
+// timeout produces ping requests for each ping target from which an ack has
+// not been received. Suspected members and other ping targets are not asked
+// to relay, as they are likely to have failed too.
 func (s *stateMachine) timeout() []packet {
-	if s.gotAck || !s.isMember(s.pingTarget) {
+	if s.observer || s.draining {
 		return nil
 	}
 	var ps []packet
-	for _, id := range s.order.IndependentSample(s.nPingReqs, s.pingTarget) {
-		ps = append(ps, s.makePingReq(id, s.pingTarget, s.members[s.pingTarget].addr))
+	for _, pr := range s.probes {
+		target := pr.target
+		if pr.gotAck || !s.isMember(target) {
+			continue
+		}
+		s.members[target].stats.DirectTimeouts++
+		k := s.failureDetector().IndirectProbes(len(s.members) + 1)
+		helpers := s.order.IndependentSampleFunc(k, func(id id) bool {
+			return s.probeOf(id) != nil || s.isSuspect(id)
+		})
+		for _, id := range helpers {
+			ps = append(ps, s.makePingReq(id, target, s.members[target].addr))
+		}
 	}
 	return ps
 }
 
+// flush returns a ping to a random member carrying messages awaiting
+// dissemination, or nil if there are no members or no such messages.
+func (s *stateMachine) flush() []packet {
+	if s.msgQueue.Len() == 0 && s.memoQueue.Len() == 0 {
+		return nil
+	}
+	ids := s.order.IndependentSample(1, "")
+	if len(ids) == 0 {
+		return nil
+	}
+	return []packet{s.makePing(ids[0])}
+}
+
+// defaultQuarantine is the time a removed id must wait before announcing
+// itself again by default.
+const defaultQuarantine = 30 * time.Second
+
 // receive processes an incoming packet and returns any necessary outgoing
 // packets and a boolean value reporting whether s can continue participating
 // in the protocol.
 func (s *stateMachine) receive(p packet) ([]packet, bool) {
-	if s.removed[p.remoteID] {
+	if s.isStranger(p) {
+		s.strangers++
 		return nil, true
 	}
+	if t, ok := s.removed.when(p.remoteID); ok {
+		if s.now().Sub(t) < s.quarantine {
+			return nil, true
+		}
+		// The removed sender has outlasted its quarantine and is evidently
+		// alive, so it may announce itself again
+		s.removed.forget(p.remoteID)
+	}
+	var refuted *message // suspicion of s that s refuted, if any
 	for _, m := range p.Msgs {
-		if m.Addr == (netip.AddrPort{}) {
+		if m.Addr == (netip.AddrPort{}) && m.NodeID == p.remoteID {
+			// The sender's own address is the one it sent from
 			m.Addr = p.remoteAddr
 		}
-		if !s.processMsg(m) {
+		if pr, ok := s.members[m.NodeID]; ok && m.NodeID == p.remoteID &&
+			m.Type == alive && m.Incarnation < pr.incarnation {
+			// The sender has restarted with the same ID and forgotten s,
+			// so s must introduce itself again, and its memos are
+			// numbered from the beginning again. Its own word on its
+			// incarnation is authoritative, and adopting it detects the
+			// restart only once.
+			pr.contacted = false
+			pr.incarnation = m.Incarnation
+			delete(s.memoBufs, m.NodeID)
+		}
+		confirms := m.Type == suspected && s.isSuspect(m.NodeID) &&
+			m.Incarnation == s.members[m.NodeID].incarnation
+		refutes, ok := s.processMsg(m)
+		if !ok {
 			return nil, false
 		}
+		if refutes {
+			refuted = m
+		}
+		if confirms {
+			s.confirmSuspicion(m.NodeID, m.Origin)
+		}
+	}
+	if pr, ok := s.members[p.remoteID]; ok && !pr.addr.IsValid() {
+		// Learned of the sender from a message without its address
+		pr.addr = p.remoteAddr
+	}
+	ps := s.processPacketType(p)
+	if refuted != nil {
+		ps = append(ps, s.refutations(p.remoteID, refuted.Origin)...)
 	}
-	return s.processPacketType(p), true
+	return ps, true
 }
 
-// processMsg processes a received message and reports whether s can continue
-// participating in the protocol.
-func (s *stateMachine) processMsg(m *message) bool {
+// isStranger reports whether p is from a sender that s does not accept
+// packets from: a non-member at an untrusted address, if s only accepts
+// packets from trusted addresses.
+func (s *stateMachine) isStranger(p packet) bool {
+	return s.trusted != nil && !s.isMember(p.remoteID) && !s.trusted[unmapAddrPort(p.remoteAddr)]
+}
+
+// trust causes s to accept packets from non-members at addr, if s only
+// accepts packets from trusted addresses.
+func (s *stateMachine) trust(addr netip.AddrPort) {
+	if s.trusted != nil {
+		s.trusted[unmapAddrPort(addr)] = true
+	}
+}
+
+// unmapAddrPort returns addr with any IPv4-mapped IPv6 address converted to
+// IPv4, so that it compares equal to the same address in either form.
+func unmapAddrPort(addr netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+}
+
+// refuteFanout is the number of members to which s sends its refutation of
+// suspicion immediately.
+const refuteFanout = 3
+
+// refutations returns packets sending s's refutation of suspicion directly to
+// the originator of the suspicion and the sender from which s learned of it,
+// if they are members, and to random other members, up to refuteFanout in
+// all. The refutation is also queued for dissemination as usual, but sending
+// it at once gives it a head start on the suspicion, which is already
+// spreading from its originator.
+func (s *stateMachine) refutations(sender, origin id) []packet {
+	var ids []id
+	if s.isMember(origin) {
+		ids = append(ids, origin)
+	}
+	if sender != origin && s.isMember(sender) {
+		ids = append(ids, sender)
+	}
+	ids = append(ids, s.order.IndependentSampleFunc(refuteFanout-len(ids), func(id id) bool {
+		return id == sender || id == origin
+	})...)
+	var ps []packet
+	for _, id := range ids {
+		ps = append(ps, packet{
+			Type:       gossip,
+			remoteID:   id,
+			remoteAddr: s.members[id].addr,
+			Msgs:       []*message{s.aliveMessage()},
+		})
+	}
+	return ps
+}
+
+// processMsg processes a received message and reports whether it was
+// suspicion of s that s refuted, and whether s can continue participating in
+// the protocol.
+func (s *stateMachine) processMsg(m *message) (refuted, ok bool) {
 	if m.NodeID == s.id {
+		if s.left {
+			// s's own departure, or stale news superseded by it
+			return false, true
+		}
 		if m.Type == suspected && m.Incarnation == s.incarnation {
 			s.incarnation++
-			s.msgQueue.Upsert(s.id, s.aliveMessage())
+			s.enqueue(s.aliveMessage())
+			return true, true
 		}
-		return m.Type != failed
+		return false, m.Type != failed
 	}
 	if s.isMemberNews(m) {
+		if s.isFull(m) || !s.admits(m) {
+			s.rejectedJoins++
+			return false, true
+		}
 		s.updateStatus(m)
-		s.msgQueue.Upsert(m.NodeID, stripMemo(m))
+		if !s.observer {
+			s.enqueue(stripMemo(m))
+		}
 	}
-	if len(m.Body) > 0 && !s.seenMemos[m.MemoID] && s.isMember(m.NodeID) {
+	if len(m.Body) > 0 && !s.seenMemos[m.MemoID] && s.isMember(m.NodeID) &&
+		(s.suspectMemos || !s.isSuspect(m.NodeID)) {
 		s.seenMemos[m.MemoID] = true
-		s.memoQueue.Upsert(m.MemoID, m)
+		if !s.observer {
+			s.upsertMemo(m)
+		}
+		s.deliverMemo(m)
+	}
+	return false, true
+}
+
+// deliverMemo calls handleMemo for m and any memos it was blocking, in order
+// of their sequence numbers. If memos preceding m have yet to arrive, m is
+// held until they do or until releaseLateMemos gives up on them. Memos that
+// arrive after a later memo from the same sender has been delivered are
+// discarded.
+//
+// The sender's first memo to arrive is held briefly, in case s began
+// receiving its memos partway through the sequence and earlier ones are
+// still in transit. Memos without sequence numbers are delivered immediately.
+func (s *stateMachine) deliverMemo(m *message) {
+	if m.Seq == 0 {
 		s.handleMemo(m.NodeID, m.Addr, m.Body)
+		return
+	}
+	b, ok := s.memoBufs[m.NodeID]
+	if !ok {
+		b = &memoBuffer{next: 1, pending: make(map[uint64]*message)}
+		s.memoBufs[m.NodeID] = b
+	}
+	if m.Seq < b.next {
+		return
+	}
+	if len(b.pending) == 0 {
+		b.since = s.period
+	}
+	b.pending[m.Seq] = m
+	s.releaseMemos(m.NodeID, b)
+}
+
+// releaseMemos delivers the consecutive memos in b starting with b.next.
+func (s *stateMachine) releaseMemos(id id, b *memoBuffer) {
+	released := false
+	for {
+		m, ok := b.pending[b.next]
+		if !ok {
+			break
+		}
+		delete(b.pending, b.next)
+		b.next++
+		released = true
+		s.handleMemo(id, m.Addr, m.Body)
+	}
+	if released {
+		b.since = s.period
+	}
+}
+
+// releaseLateMemos skips over missing memos that have blocked delivery for
+// memoReorderPeriods periods.
+func (s *stateMachine) releaseLateMemos() {
+	for _, id := range sortedIDs(s.memoBufs) {
+		b := s.memoBufs[id]
+		if len(b.pending) == 0 || s.period-b.since < memoReorderPeriods {
+			continue
+		}
+		first := true
+		for seq := range b.pending {
+			if first || seq < b.next {
+				b.next = seq
+				first = false
+			}
+		}
+		s.releaseMemos(id, b)
 	}
-	return true
 }
 
 // updateStatus updates a node's membership status based on a received message
@@ -204,69 +786,205 @@ func (s *stateMachine) processMsg(m *message) bool {
 func (s *stateMachine) updateStatus(m *message) {
 	id := m.NodeID
 	if m.Type == failed {
-		s.remove(id)
+		s.remove(id, m.Reason)
 		return
 	}
-	if !s.isMember(id) {
-		s.members[id] = new(profile)
+	joined := !s.isMember(id)
+	if joined {
+		s.members[id] = &profile{lastAck: s.period}
 		s.order.Add(id)
-		s.handleJoin(id, m.Addr)
+		s.generation++
+		s.joined = true
+	}
+	if m.Incarnation != s.members[id].incarnation {
+		s.members[id].stats = PeerStats{}
 	}
 	s.members[id].incarnation = m.Incarnation
-	s.members[id].addr = m.Addr
+	if m.Addr.IsValid() {
+		// Messages about members relayed without an address must not
+		// erase one already known
+		s.members[id].addr = m.Addr
+	}
+	if joined {
+		s.handleJoin(id, m.Addr)
+	}
 	switch m.Type {
 	case alive:
+		s.members[id].lastSeen = s.now()
+		if s.isSuspect(id) {
+			s.members[id].flapping = true
+			s.members[id].acks = 0
+			s.members[id].misses = 0
+		}
 		delete(s.suspects, id)
+		delete(s.confirms, id)
+		s.members[id].suspectedBy = ""
 	case suspected:
 		s.suspects[id] = 0
+		delete(s.confirms, id)
+		s.members[id].suspectedBy = m.Origin
 	}
 }
 
-// remove removes an id from the list and calls handleFail if it was a member.
-func (s *stateMachine) remove(id id) {
+// remove calls handleFail with reason if an id is a member, while its profile
+// remains available to the handler, and then removes it from the list.
+func (s *stateMachine) remove(id id, reason FailReason) {
 	if !s.isMember(id) {
 		return
 	}
+	s.handleFail(id, reason)
 	delete(s.members, id)
 	delete(s.suspects, id)
-	s.removed[id] = true
+	delete(s.confirms, id)
+	delete(s.partitions, id)
+	delete(s.memoBufs, id)
+	s.removed.add(id, s.now())
 	s.order.Remove(id)
-	s.handleFail(id)
+	s.generation++
 }
 
 // processPacketType processes an incoming packet and returns any necessary
-// outgoing packets.
+// outgoing packets. s acknowledges pings from non-members, such as observers
+// or members whose introductory messages were lost, at the address from which
+// they were sent, sharing its membership only with observers, but does not
+// relay their ping requests, nor requests to ping
+// targets it has yet to learn of. A draining state machine relays no ping
+// requests at all. An observer acknowledges nothing.
 func (s *stateMachine) processPacketType(p packet) []packet {
+	if s.observer && p.Type != ack {
+		return nil
+	}
 	switch p.Type {
 	case ping:
-		return []packet{s.makeAck(p.remoteID)}
+		if !s.isMember(p.remoteID) {
+			return []packet{s.makeNonMemberAck(p)}
+		}
+		return []packet{s.makeAck(p.remoteID, p.ProbeSeq)}
 	case pingReq:
-		s.pingReqs[p.remoteID] = p.TargetID
+		if s.draining || !s.isMember(p.remoteID) || !s.isMember(p.TargetID) {
+			return nil
+		}
+		// Ping each target at most once per period, however many members
+		// request it; the ack is relayed to all of them
+		pinged := false
+		for req := range s.pingReqs {
+			pinged = pinged || req.target == p.TargetID
+		}
+		s.pingReqs[pingRequest{p.remoteID, p.TargetID}] = p.ProbeSeq
+		if pinged {
+			return nil
+		}
 		return []packet{s.makePing(p.TargetID)}
 	case ack:
-		if p.remoteID == s.pingTarget || p.TargetID == s.pingTarget {
-			s.gotAck = true
+		if pr, ok := s.members[p.remoteID]; ok {
+			pr.lastSeen = s.now()
+			pr.lastAck = s.period
 		}
-		var ps []packet
-		for src, target := range s.pingReqs {
-			if target == p.remoteID {
-				ps = append(ps, s.makeReqAck(src, p.remoteID, p.remoteAddr))
-				delete(s.pingReqs, src)
+		switch {
+		case s.concluded:
+		case p.ProbeSeq != 0 && p.ProbeSeq != s.probeSeq:
+			// A late ack to an earlier probe
+		case s.probeOf(p.remoteID) != nil:
+			pr := s.probeOf(p.remoteID)
+			if m, ok := s.members[p.remoteID]; ok && !pr.directAck {
+				m.stats.AcksReceived++
+			}
+			pr.gotAck = true
+			pr.directAck = true
+		case s.probeOf(p.TargetID) != nil:
+			pr := s.probeOf(p.TargetID)
+			if m, ok := s.members[pr.target]; ok && !pr.gotAck {
+				m.stats.IndirectRescues++
+			}
+			pr.gotAck = true
+			pr.relays[p.remoteID] = true
+		}
+		var reqs []pingRequest
+		for req := range s.pingReqs {
+			if req.target == p.remoteID && s.isMember(req.requester) {
+				reqs = append(reqs, req)
 			}
 		}
+		sort.Slice(reqs, func(i, j int) bool { return reqs[i].requester < reqs[j].requester })
+		var ps []packet
+		for _, req := range reqs {
+			ps = append(ps, s.makeReqAck(req.requester, p.remoteID, p.remoteAddr, s.pingReqs[req]))
+			delete(s.pingReqs, req)
+		}
 		return ps
 	}
 	return nil
 }
 
-// disseminationFactor returns 2*log(n) rounded up, where n is the size of the
-// network. Each message must be sent a small multiple of log(n) times to ensure
-// reliable dissemination. Consequently, this is also the dissemination
-// timescale, and by extension the number of protocol periods to wait before
-// declaring a suspect failed.
+// disseminationFactor returns retransmitMult*log(n) rounded up, where n is
+// the size of the network: the number of times to send each membership
+// message. Each message must be sent a small multiple of log(n) times to
+// ensure reliable dissemination. The number of protocol periods to wait
+// before declaring a suspect failed is determined separately, by the
+// failure detector.
 func (s *stateMachine) disseminationFactor() int {
-	const λ = 2 // must be greater than 1
-	return int(math.Ceil(λ * math.Log(float64(len(s.members)+1))))
+	// TODO: dissemination alone occasionally leaves members that join at
+	// about the same time through different seeds unaware of each other,
+	// as news of each is retired before reaching the other and nothing
+	// sends it again. Some form of anti-entropy, such as a periodic
+	// exchange of membership lists, would let the network converge.
+	return int(math.Ceil(float64(s.retransmitMult) * math.Log(float64(len(s.members)+1))))
+}
+
+// memoQuota returns the number of times to send each memo: memoBudget if it is
+// positive, or else the dissemination factor, as for membership messages.
+func (s *stateMachine) memoQuota() int {
+	if s.memoBudget > 0 {
+		return s.memoBudget
+	}
+	return s.disseminationFactor()
+}
+
+// suspicionTimeout returns the number of protocol periods to wait before
+// declaring a suspect failed in the absence of confirmations: the detector's
+// timeout scaled by suspicionScale, so that different nodes time out in
+// different periods.
+func (s *stateMachine) suspicionTimeout() int {
+	return s.scaledTimeout(0)
+}
+
+// suspectTimeout returns the number of protocol periods to wait before
+// declaring the suspect id failed, given the confirmations of its suspicion.
+func (s *stateMachine) suspectTimeout(id id) int {
+	return s.scaledTimeout(len(s.confirms[id]))
+}
+
+func (s *stateMachine) scaledTimeout(confirmations int) int {
+	d := s.failureDetector().SuspicionTimeout(len(s.members)+1, confirmations)
+	t := int(math.Round(float64(d) * s.suspicionScale))
+	if t < 1 {
+		return 1
+	}
+	return t
+}
+
+// failureDetector returns s's FailureDetector.
+func (s *stateMachine) failureDetector() FailureDetector {
+	if s.detector == nil {
+		return swimDetector{}
+	}
+	return s.detector
+}
+
+// confirmSuspicion records that the member origin independently suspected
+// target, which s already suspects. Suspicion originated by s itself or by
+// the originator of s's suspicion confirms nothing, however many members
+// relay it, nor does suspicion of unknown origin.
+func (s *stateMachine) confirmSuspicion(target, origin id) {
+	if origin == "" || origin == target || origin == s.id || origin == s.members[target].suspectedBy {
+		return
+	}
+	c, ok := s.confirms[target]
+	if !ok {
+		c = make(map[id]bool)
+		s.confirms[target] = c
+	}
+	c[origin] = true
 }
 
 // isMember reports whether an id is a member.
@@ -281,6 +999,19 @@ func (s *stateMachine) isSuspect(id id) bool {
 	return ok
 }
 
+// isFull reports whether m would add a member to a network that has reached
+// its maximum size.
+func (s *stateMachine) isFull(m *message) bool {
+	return s.maxMembers > 0 && m.Type != failed && !s.isMember(m.NodeID) &&
+		len(s.members)+1 >= s.maxMembers
+}
+
+// admits reports whether s's join filter permits m to add a member.
+func (s *stateMachine) admits(m *message) bool {
+	return s.joinFilter == nil || m.Type == failed || s.isMember(m.NodeID) ||
+		s.joinFilter(m.NodeID, m.Addr)
+}
+
 // isMemberNews reports whether m contains new membership status information.
 func (s *stateMachine) isMemberNews(m *message) bool {
 	if m == nil {
@@ -288,7 +1019,8 @@ func (s *stateMachine) isMemberNews(m *message) bool {
 	}
 	id := m.NodeID
 	if !s.isMember(id) {
-		return !s.removed[id]
+		_, removed := s.removed.when(id)
+		return !removed
 	}
 	if m.Type == failed {
 		return true
@@ -301,42 +1033,94 @@ func (s *stateMachine) isMemberNews(m *message) bool {
 }
 
 func (s *stateMachine) makePing(dst id) packet {
-	return s.makePacket(ping, dst, "", netip.AddrPort{})
+	p := s.makePacket(ping, dst, "", netip.AddrPort{})
+	p.ProbeSeq = s.probeSeq
+	return p
 }
 
-func (s *stateMachine) makeAck(dst id) packet {
-	return s.makePacket(ack, dst, "", netip.AddrPort{})
+func (s *stateMachine) makeAck(dst id, seq uint64) packet {
+	p := s.makePacket(ack, dst, "", netip.AddrPort{})
+	p.ProbeSeq = seq
+	return p
+}
+
+// makeNonMemberAck returns an ack to p, a ping from a non-member, at the
+// address from which it was sent. The ack carries no messages from the queues,
+// whose quotas are reserved for members. If p is from an observer, the ack
+// carries an alive message about s and the status of a random sample of its
+// members, so that an observer probing members in turn eventually learns of
+// them all; otherwise it carries nothing, so that a spoofed ping can neither
+// read the membership nor elicit a reply larger than itself.
+func (s *stateMachine) makeNonMemberAck(p packet) packet {
+	a := packet{
+		Type:       ack,
+		remoteID:   p.remoteID,
+		remoteAddr: p.remoteAddr,
+		ProbeSeq:   p.ProbeSeq,
+	}
+	if p.Observer {
+		a.Msgs = []*message{s.aliveMessage()}
+		for _, id := range s.order.IndependentSample(s.maxMsgs-1, p.remoteID) {
+			a.Msgs = append(a.Msgs, s.memberMessage(id))
+		}
+	}
+	return a
+}
+
+// makeJoin returns a join request to the node at addr: a ping introducing s,
+// or, if s is an observer, asking for a sample of the node's members.
+func (s *stateMachine) makeJoin(addr netip.AddrPort) packet {
+	p := packet{Type: ping, remoteAddr: addr, Observer: s.observer}
+	if !s.observer {
+		p.Msgs = []*message{s.aliveMessage()}
+	}
+	return p
 }
 
 func (s *stateMachine) makePingReq(dst, target id, targetAddr netip.AddrPort) packet {
-	return s.makePacket(pingReq, dst, target, targetAddr)
+	p := s.makePacket(pingReq, dst, target, targetAddr)
+	p.ProbeSeq = s.probeSeq
+	return p
 }
 
-func (s *stateMachine) makeReqAck(dst, target id, targetAddr netip.AddrPort) packet {
-	return s.makePacket(ack, dst, target, targetAddr)
+func (s *stateMachine) makeReqAck(dst, target id, targetAddr netip.AddrPort, seq uint64) packet {
+	p := s.makePacket(ack, dst, target, targetAddr)
+	p.ProbeSeq = seq
+	return p
 }
 
-// makePacket assembles a packet and populates it with messages. If dst has
-// not been sent to before, one of the messages is an introductory alive
-// message.
+// checkPacket, if set, is called with each packet that makePacket assembles
+// and the state machine's message limit. Tests set it to assert that packets
+// respect the limit, and so the MTU constraint.
+var checkPacket func(p packet, maxMsgs int)
+
+// makePacket assembles a packet and populates it with up to maxMsgs
+// messages. If dst has not been sent to before, one of the messages is an
+// introductory alive message.
 func (s *stateMachine) makePacket(typ packetType, dst, target id, targetAddr netip.AddrPort) packet {
 	// TODO: treat message sizes vs. packet capacity in more detail
 	var msgs []*message
-	if !s.members[dst].contacted {
+	if !s.members[dst].contacted && !s.observer && !s.left {
 		s.members[dst].contacted = true
 		msgs = append(msgs, s.aliveMessage())
 	}
-	if s.memoQueue.Len() > 0 {
-		msgs = append(msgs, s.memoQueue.Pop())
+	if m, ok := s.memoQueue.TryPop(); ok {
+		msgs = append(msgs, m)
+		s.memoSends++
 	}
-	return packet{
+	p := packet{
 		Type:       typ,
 		remoteID:   dst,
 		remoteAddr: s.members[dst].addr,
 		TargetID:   target,
 		TargetAddr: targetAddr,
+		Observer:   s.observer,
 		Msgs:       append(msgs, s.msgQueue.PopN(s.maxMsgs-len(msgs))...),
 	}
+	if checkPacket != nil {
+		checkPacket(p, s.maxMsgs)
+	}
+	return p
 }
 
 // makeMessagePing returns a ping that delivers a single message to its subject.
@@ -345,6 +1129,7 @@ func (s *stateMachine) makeMessagePing(m *message) packet {
 		Type:       ping,
 		remoteID:   m.NodeID,
 		remoteAddr: m.Addr,
+		ProbeSeq:   s.probeSeq,
 		Msgs:       []*message{m},
 	}
 }
@@ -354,10 +1139,32 @@ func (s *stateMachine) aliveMessage() *message {
 	return &message{
 		Type:        alive,
 		NodeID:      s.id,
+		Addr:        s.addr,
 		Incarnation: s.incarnation,
 	}
 }
 
+// updateAddr sets the address s announces to addr and queues news of it under
+// a new incarnation, which supersedes the address members have for s.
+func (s *stateMachine) updateAddr(addr netip.AddrPort) {
+	s.addr = addr
+	s.incarnation++
+	s.enqueue(s.aliveMessage())
+}
+
+// memberMessage returns a message reporting the status of a member.
+func (s *stateMachine) memberMessage(id id) *message {
+	if s.isSuspect(id) {
+		return s.suspectedMessage(id)
+	}
+	return &message{
+		Type:        alive,
+		NodeID:      id,
+		Incarnation: s.members[id].incarnation,
+		Addr:        s.members[id].addr,
+	}
+}
+
 // suspectedMessage returns a message reporting an id as suspected.
 func (s *stateMachine) suspectedMessage(id id) *message {
 	return &message{
@@ -365,6 +1172,7 @@ func (s *stateMachine) suspectedMessage(id id) *message {
 		NodeID:      id,
 		Incarnation: s.members[id].incarnation,
 		Addr:        s.members[id].addr,
+		Origin:      s.members[id].suspectedBy,
 	}
 }
 
@@ -377,14 +1185,138 @@ func (s *stateMachine) failedMessage(id id) *message {
 	}
 }
 
+// evict removes the member id as failed without probing it and, unless s is
+// an observer, queues news of its failure for dissemination. It reports
+// whether id was a member.
+func (s *stateMachine) evict(id id) bool {
+	if !s.isMember(id) {
+		return false
+	}
+	if !s.observer {
+		s.enqueue(s.failedMessage(id))
+	}
+	s.remove(id, Failed)
+	return true
+}
+
+// drain prepares s to leave the network. A draining state machine continues
+// to acknowledge probes and disseminate messages and memos, but no longer
+// suspects its ping targets or relays ping requests, so that its departure
+// does not leave other members' probes unresolved.
+func (s *stateMachine) drain() {
+	s.draining = true
+}
+
+// leave announces s's departure from the network by queueing a message
+// reporting s as failed for the reason Left. Once s has left, it no longer
+// refutes suspicion or introduces itself to members.
+func (s *stateMachine) leave() {
+	s.drain()
+	if s.observer || s.left {
+		return
+	}
+	s.left = true
+	s.enqueue(&message{
+		Type:        failed,
+		NodeID:      s.id,
+		Incarnation: s.incarnation,
+		Reason:      Left,
+	})
+}
+
+// isAnnouncing reports whether s's departure announcement is still being
+// disseminated.
+func (s *stateMachine) isAnnouncing() bool {
+	found := false
+	s.msgQueue.Range(func(key id, _ *message, _ int) bool {
+		found = key == s.id
+		return !found
+	})
+	return found
+}
+
 // addMemo adds a new memo carrying b to the memo queue.
 func (s *stateMachine) addMemo(b []byte) {
+	s.memoSeq++
+	s.queueMemo(randID(), s.memoSeq, b)
+}
+
+// addMemoWithID queues a memo with the given ID and body for dissemination,
+// unless s has already seen a memo with that ID. The memo has no sequence
+// number, so peers deliver it as soon as it arrives.
+func (s *stateMachine) addMemoWithID(memoID id, b []byte) {
+	if s.seenMemos[memoID] {
+		return
+	}
+	s.queueMemo(memoID, 0, b)
+}
+
+// queueMemo queues a memo from s for dissemination, delivering it to s as
+// well if s.selfMemos is set.
+func (s *stateMachine) queueMemo(memoID id, seq uint64, b []byte) {
 	m := s.aliveMessage()
-	memoID := randID()
 	m.MemoID = memoID
 	m.Body = b
-	s.memoQueue.Upsert(memoID, m)
+	m.Seq = seq
+	s.upsertMemo(m)
 	s.seenMemos[memoID] = true
+	if s.selfMemos {
+		s.handleMemo(s.id, s.addr, b)
+	}
+}
+
+// upsertMemo adds the memo m to the memo queue, recording the order in which
+// it was queued.
+func (s *stateMachine) upsertMemo(m *message) {
+	s.memoClock++
+	m.queued = s.memoClock
+	s.memoQueue.Upsert(m.MemoID, m)
+}
+
+// memoLess reports whether s sends the memo a before b when both have been
+// sent equally many times: if a was queued after b, when s sends newer memos
+// first, or else if a's ID is less than b's.
+func (s *stateMachine) memoLess(a, b *message) bool {
+	if s.newestFirst {
+		return a.queued > b.queued
+	}
+	return a.MemoID < b.MemoID
+}
+
+// urgentBoost is the number of extra times news of failure and suspicion,
+// and refutations of suspicion, are sent, ahead of routine news.
+const urgentBoost = 2
+
+// enqueue queues the membership message m for dissemination. Urgent messages
+// are boosted so that they spread quickly even while the queue is busy with
+// routine news of members being alive: failed and suspected messages, and
+// s's refutation of suspicion, which must spread as fast as the suspicion
+// itself to avoid a false positive.
+func (s *stateMachine) enqueue(m *message) {
+	if m.Type != alive || m.NodeID == s.id {
+		s.msgQueue.UpsertBoost(m.NodeID, m, urgentBoost)
+		return
+	}
+	s.msgQueue.Upsert(m.NodeID, m)
+}
+
+// isMoreUrgent reports whether a should be disseminated before b if both
+// have been sent equally many times. News of failure is the most urgent,
+// followed by suspicion, which its subject must learn of in time to refute
+// it, and then by news of members being alive.
+func isMoreUrgent(a, b *message) bool {
+	return urgency(a.Type) > urgency(b.Type)
+}
+
+// urgency ranks message types by the urgency of their dissemination.
+func urgency(t msgType) int {
+	switch t {
+	case failed:
+		return 2
+	case suspected:
+		return 1
+	}
+	return 0
 }
 
 // stripMemo returns a copy of m without its memo data, if any.
@@ -393,5 +1325,6 @@ func stripMemo(m *message) *message {
 	*n = *m
 	n.MemoID = ""
 	n.Body = nil
+	n.Seq = 0
 	return n
 }
@@ -1,10 +1,31 @@
 package swim
 
 import (
+	"fmt"
+	"net/netip"
 	"reflect"
 	"testing"
+	"time"
 )
 
+func init() {
+	// Every packet assembled in any test must respect the message limit
+	checkPacket = func(p packet, maxMsgs int) {
+		if len(p.Msgs) > maxMsgs {
+			panic(fmt.Sprintf("packet carries %v messages, exceeding limit of %v", len(p.Msgs), maxMsgs))
+		}
+	}
+}
+
+// newTestStateMachine returns a state machine whose handlers do nothing.
+func newTestStateMachine() *stateMachine {
+	return newStateMachine(
+		func(id, netip.AddrPort) {},
+		func(id, netip.AddrPort, []byte) {},
+		func(id, FailReason) {},
+	)
+}
+
 func TestIsMemberNews(t *testing.T) {
 	s := &stateMachine{
 		members: map[id]*profile{
@@ -14,7 +35,7 @@ func TestIsMemberNews(t *testing.T) {
 			"jkl": {incarnation: 1},
 		},
 		suspects: map[id]int{"def": 0, "jkl": 0},
-		removed:  map[id]bool{"xyz": true},
+		removed:  removedMap{"xyz": {}},
 	}
 	for _, tt := range []struct {
 		m    *message
@@ -87,3 +108,1294 @@ func TestStripMemo(t *testing.T) {
 		}
 	}
 }
+
+func TestSuspicionTimeout(t *testing.T) {
+	s := &stateMachine{members: make(map[id]*profile)}
+	for i := 0; i < 99; i++ {
+		s.members[randID()] = new(profile)
+	}
+	// disseminationFactor is ⌈2 ln 100⌉ = 10
+	for _, tt := range []struct {
+		scale float64
+		want  int
+	}{
+		{1, 10},
+		{0.9, 9},
+		{1.1, 11},
+		{1.04, 10},
+		{0.01, 1},
+	} {
+		s.suspicionScale = tt.scale
+		if got := s.suspicionTimeout(); got != tt.want {
+			t.Errorf("suspicionTimeout() with scale %v: got %v, expected %v", tt.scale, got, tt.want)
+		}
+	}
+}
+
+func TestRetransmitMultiplier(t *testing.T) {
+	s := newTestStateMachine()
+	for i := 0; i < 99; i++ {
+		s.members[randID()] = new(profile)
+	}
+	// ln 100 ≈ 4.6, and the suspicion timeout is ⌈2 ln 100⌉ = 10 regardless
+	for _, tt := range []struct {
+		mult int
+		want int
+	}{
+		{1, 5},
+		{2, 10},
+		{3, 14},
+		{4, 19},
+	} {
+		s.retransmitMult = tt.mult
+		if got := s.disseminationFactor(); got != tt.want {
+			t.Errorf("disseminationFactor() with multiplier %v: got %v, expected %v", tt.mult, got, tt.want)
+		}
+		if got := s.memoQuota(); got != tt.want {
+			t.Errorf("memoQuota() with multiplier %v: got %v, expected %v", tt.mult, got, tt.want)
+		}
+		if got := s.suspicionTimeout(); got != 10 {
+			t.Errorf("suspicionTimeout() with multiplier %v: got %v, expected 10", tt.mult, got)
+		}
+	}
+}
+
+func TestHysteresis(t *testing.T) {
+	s := newTestStateMachine()
+	s.hysteresis = 2
+	addr := netip.MustParseAddrPort("127.0.0.1:1000")
+	s.receive(packet{
+		Type:     ping,
+		remoteID: "abc",
+		Msgs:     []*message{{Type: alive, NodeID: "abc", Addr: addr}},
+	})
+	probe := func(ack bool) {
+		s.setProbes("abc")
+		s.probes[0].gotAck = ack
+		s.tick()
+	}
+
+	// A member that has not refuted suspicion is suspected after one miss
+	probe(false)
+	if !s.isSuspect("abc") {
+		t.Fatal("not suspected after a missed probe")
+	}
+	s.receive(packet{
+		Type:     ping,
+		remoteID: "abc",
+		Msgs:     []*message{{Type: alive, NodeID: "abc", Addr: addr, Incarnation: 1}},
+	})
+	if s.isSuspect("abc") {
+		t.Fatal("suspected after refutation")
+	}
+
+	// A flapping member is suspected after hysteresis misses
+	probe(false)
+	if s.isSuspect("abc") {
+		t.Fatal("flapping member suspected after one missed probe")
+	}
+	probe(false)
+	if !s.isSuspect("abc") {
+		t.Fatal("flapping member not suspected after two missed probes")
+	}
+	s.receive(packet{
+		Type:     ping,
+		remoteID: "abc",
+		Msgs:     []*message{{Type: alive, NodeID: "abc", Addr: addr, Incarnation: 2}},
+	})
+
+	// An interrupted run of misses does not count
+	probe(false)
+	probe(true)
+	probe(false)
+	if s.isSuspect("abc") {
+		t.Fatal("flapping member suspected after nonconsecutive misses")
+	}
+
+	// hysteresis acks stop a member flapping
+	probe(true)
+	probe(true)
+	probe(false)
+	if !s.isSuspect("abc") {
+		t.Fatal("stable member not suspected after a missed probe")
+	}
+}
+
+func TestSmallNetworkMisses(t *testing.T) {
+	s := newTestStateMachine()
+	s.smallSize = 3
+	s.smallMisses = 2
+	s.receive(packet{Type: ping, remoteID: "abc", Msgs: []*message{{Type: alive, NodeID: "abc"}}})
+	probe := func(ack bool) {
+		s.setProbes("abc")
+		s.probes[0].gotAck = ack
+		s.tick()
+	}
+
+	// In a two-node network, a single dropped ack is tolerated
+	probe(false)
+	if s.isSuspect("abc") {
+		t.Fatal("suspected after one missed probe")
+	}
+	probe(true)
+	probe(false)
+	if s.isSuspect("abc") {
+		t.Fatal("suspected after nonconsecutive misses")
+	}
+	probe(false)
+	if !s.isSuspect("abc") {
+		t.Fatal("not suspected after two consecutive missed probes")
+	}
+
+	// In a network of size at least smallSize, one miss suffices
+	s.receive(packet{Type: ping, remoteID: "def", Msgs: []*message{{Type: alive, NodeID: "def"}}})
+	s.setProbes("def")
+	s.tick()
+	if !s.isSuspect("def") {
+		t.Error("member of a larger network not suspected after one missed probe")
+	}
+}
+
+func TestFlush(t *testing.T) {
+	s := newTestStateMachine()
+	if ps := s.flush(); ps != nil {
+		t.Errorf("flush with no members: got %v, expected nil", ps)
+	}
+	s.receive(packet{
+		Type:     ping,
+		remoteID: "abc",
+		Msgs:     []*message{{Type: alive, NodeID: "abc"}},
+	})
+	for s.msgQueue.Len() > 0 {
+		s.msgQueue.Pop()
+	}
+	if ps := s.flush(); ps != nil {
+		t.Errorf("flush with no messages: got %v, expected nil", ps)
+	}
+	s.addMemo([]byte("Hello, SWIM!"))
+	ps := s.flush()
+	if len(ps) != 1 || ps[0].remoteID != "abc" {
+		t.Fatalf("flush: got %v, expected a packet to abc", ps)
+	}
+	var found bool
+	for _, m := range ps[0].Msgs {
+		found = found || string(m.Body) == "Hello, SWIM!"
+	}
+	if !found {
+		t.Errorf("flush: memo not in %v", ps[0].Msgs)
+	}
+}
+
+func TestPartitionEvidence(t *testing.T) {
+	s := newTestStateMachine()
+	for _, id := range []id{"abc", "def"} {
+		s.receive(packet{
+			Type:     ping,
+			remoteID: id,
+			Msgs:     []*message{{Type: alive, NodeID: id}},
+		})
+	}
+	probe := func(direct bool) {
+		s.tick()
+		s.setProbes("abc")
+		if direct {
+			s.receive(packet{Type: ack, remoteID: "abc"})
+		} else {
+			s.receive(packet{Type: ack, remoteID: "def", TargetID: "abc"})
+		}
+	}
+	probes := func() int {
+		if e, ok := s.partitions["abc"]; ok {
+			return e.probes
+		}
+		return 0
+	}
+
+	probe(false)
+	probe(false)
+	s.tick()
+	if got := probes(); got != 2 {
+		t.Fatalf("after two indirect acks: got %v probes, expected 2", got)
+	}
+	if !s.partitions["abc"].relays["def"] {
+		t.Errorf("relay def not recorded")
+	}
+	probe(true)
+	s.tick()
+	if got := probes(); got != 0 {
+		t.Errorf("after a direct ack: got %v probes, expected 0", got)
+	}
+
+	// Evidence that does not recur expires
+	probe(false)
+	for i := 0; i <= partitionRounds*3; i++ {
+		s.tick()
+	}
+	if got := probes(); got != 0 {
+		t.Errorf("after expiry: got %v probes, expected 0", got)
+	}
+}
+
+func TestWithoutSuspicion(t *testing.T) {
+	var removed []id
+	s := newStateMachine(
+		func(id, netip.AddrPort) {},
+		func(id, netip.AddrPort, []byte) {},
+		func(id id, _ FailReason) { removed = append(removed, id) },
+	)
+	s.suspicion = false
+	s.receive(packet{
+		Type:     ping,
+		remoteID: "abc",
+		Msgs:     []*message{{Type: alive, NodeID: "abc"}},
+	})
+	s.setProbes("abc")
+	ps := s.tick()
+	if s.isMember("abc") || s.isSuspect("abc") {
+		t.Fatal("expired ping target not removed")
+	}
+	if !reflect.DeepEqual(removed, []id{"abc"}) {
+		t.Errorf("removed: got %v, expected [abc]", removed)
+	}
+	if len(ps) == 0 || ps[0].Msgs[0].Type != failed {
+		t.Errorf("tick: got %v, expected a failed message ping", ps)
+	}
+}
+
+// A memo message is itself an alive message about its sender, so processMsg
+// learns of the sender's membership before it considers the memo, regardless
+// of where the sender's other messages appear in the packet.
+func TestMemoBeforeAlive(t *testing.T) {
+	var memos []string
+	s := newStateMachine(
+		func(id, netip.AddrPort) {},
+		func(_ id, _ netip.AddrPort, memo []byte) { memos = append(memos, string(memo)) },
+		func(id, FailReason) {},
+	)
+	s.receive(packet{
+		Type:     ping,
+		remoteID: "def",
+		Msgs: []*message{
+			{Type: alive, NodeID: "abc", MemoID: "123", Body: []byte("Hello, SWIM!")},
+			{Type: alive, NodeID: "abc"},
+			{Type: alive, NodeID: "def"},
+		},
+	})
+	if !reflect.DeepEqual(memos, []string{"Hello, SWIM!"}) {
+		t.Errorf("memos: got %q, expected [Hello, SWIM!]", memos)
+	}
+	if !s.isMember("abc") {
+		t.Error("memo sender not a member")
+	}
+}
+
+func TestSuspectMemos(t *testing.T) {
+	for _, deliver := range []bool{true, false} {
+		var memos []string
+		s := newStateMachine(
+			func(id, netip.AddrPort) {},
+			func(_ id, _ netip.AddrPort, memo []byte) { memos = append(memos, string(memo)) },
+			func(id, FailReason) {},
+		)
+		s.suspectMemos = deliver
+		memo := packet{
+			Type:     gossip,
+			remoteID: "def",
+			Msgs:     []*message{{Type: alive, NodeID: "abc", MemoID: "123", Body: []byte("memo")}},
+		}
+		s.receive(packet{
+			Type:     gossip,
+			remoteID: "def",
+			Msgs: []*message{
+				{Type: alive, NodeID: "def"},
+				{Type: suspected, NodeID: "abc"},
+			},
+		})
+		s.receive(memo)
+		if got := len(memos) == 1; got != deliver {
+			t.Errorf("deliver %v: memo from suspect delivered: %v", deliver, got)
+		}
+
+		// Once the suspicion is refuted, the memo is delivered
+		s.receive(packet{Type: gossip, remoteID: "abc", Msgs: []*message{{Type: alive, NodeID: "abc", Incarnation: 1}}})
+		s.receive(memo)
+		if !reflect.DeepEqual(memos, []string{"memo"}) {
+			t.Errorf("deliver %v: memos: got %q, expected [memo]", deliver, memos)
+		}
+	}
+}
+
+func TestGossipFanout(t *testing.T) {
+	s := newTestStateMachine()
+	s.receive(packet{
+		Type:     ping,
+		remoteID: "abc",
+		Msgs: []*message{
+			{Type: alive, NodeID: "abc"},
+			{Type: alive, NodeID: "def"},
+			{Type: alive, NodeID: "ghi"},
+		},
+	})
+	countGossip := func(ps []packet) (n int) {
+		for _, p := range ps {
+			if p.Type == gossip {
+				if s.probeOf(p.remoteID) != nil {
+					t.Errorf("gossip to ping target %v", p.remoteID)
+				}
+				n++
+			}
+		}
+		return n
+	}
+
+	s.addMemo([]byte("Hello, SWIM!"))
+	if n := countGossip(s.tick()); n != 0 {
+		t.Errorf("fanout 0: got %v gossip packets, expected 0", n)
+	}
+	s.gossipFanout = 2
+	s.addMemo([]byte("Hello again, SWIM!"))
+	if n := countGossip(s.tick()); n != 2 {
+		t.Errorf("fanout 2: got %v gossip packets, expected 2", n)
+	}
+	for s.msgQueue.Len() > 0 {
+		s.msgQueue.Pop()
+	}
+	for s.memoQueue.Len() > 0 {
+		s.memoQueue.Pop()
+	}
+	s.probes[0].gotAck = true
+	if n := countGossip(s.tick()); n != 0 {
+		t.Errorf("empty queues: got %v gossip packets, expected 0", n)
+	}
+}
+
+func TestMemoOrder(t *testing.T) {
+	var memos []string
+	s := newStateMachine(
+		func(id, netip.AddrPort) {},
+		func(_ id, _ netip.AddrPort, memo []byte) { memos = append(memos, string(memo)) },
+		func(id, FailReason) {},
+	)
+	incarnation := 1
+	memo := func(seq uint64) {
+		body := fmt.Sprint(seq)
+		s.receive(packet{
+			Type:     ping,
+			remoteID: "abc",
+			Msgs: []*message{{
+				Type:        alive,
+				NodeID:      "abc",
+				Incarnation: incarnation,
+				MemoID:      id(fmt.Sprintf("memo%v.%v", incarnation, seq)),
+				Body:        []byte(body),
+				Seq:         seq,
+			}},
+		})
+	}
+	expect := func(want ...string) {
+		t.Helper()
+		if !reflect.DeepEqual(memos, want) {
+			t.Errorf("memos: got %q, expected %q", memos, want)
+		}
+		memos = nil
+	}
+
+	memo(2)
+	expect()
+	memo(1)
+	expect("1", "2")
+
+	// A gap is skipped after memoReorderPeriods
+	memo(4)
+	memo(5)
+	expect()
+	for i := 0; i < memoReorderPeriods; i++ {
+		s.tick()
+	}
+	expect("4", "5")
+
+	// A memo that arrives after its successors is discarded
+	memo(3)
+	expect()
+	memo(6)
+	expect("6")
+
+	// abc restarts with the same ID and numbers its memos from 1 again
+	incarnation = 0
+	memo(1)
+	memo(2)
+	expect("1", "2")
+}
+
+func TestLastSeen(t *testing.T) {
+	s := newTestStateMachine()
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+	s.receive(packet{
+		Type:     ping,
+		remoteID: "abc",
+		Msgs:     []*message{{Type: alive, NodeID: "abc"}},
+	})
+	if got := s.members["abc"].lastSeen; !got.Equal(now) {
+		t.Errorf("after alive: got %v, expected %v", got, now)
+	}
+
+	now = now.Add(time.Second)
+	s.receive(packet{
+		Type:     ping,
+		remoteID: "def",
+		Msgs: []*message{
+			{Type: alive, NodeID: "def"},
+			{Type: suspected, NodeID: "abc"},
+		},
+	})
+	if got := s.members["abc"].lastSeen; !got.Equal(now.Add(-time.Second)) {
+		t.Errorf("after suspected: got %v, expected %v", got, now.Add(-time.Second))
+	}
+	s.receive(packet{Type: ack, remoteID: "abc"})
+	if got := s.members["abc"].lastSeen; !got.Equal(now) {
+		t.Errorf("after ack: got %v, expected %v", got, now)
+	}
+}
+
+func TestMaxIdle(t *testing.T) {
+	s := newTestStateMachine()
+	s.maxIdle = 3
+	s.receive(packet{
+		Type:     ping,
+		remoteID: "abc",
+		Msgs: []*message{
+			{Type: alive, NodeID: "abc"},
+			{Type: alive, NodeID: "def"},
+		},
+	})
+	// Every probe succeeds, but only def acknowledges directly
+	tick := func() {
+		s.tick()
+		s.probes[0].gotAck = true
+		s.receive(packet{Type: ack, remoteID: "def"})
+	}
+	for i := 0; i < s.maxIdle; i++ {
+		tick()
+	}
+	if s.isSuspect("abc") {
+		t.Fatal("abc suspected before maxIdle periods")
+	}
+	tick()
+	if !s.isSuspect("abc") {
+		t.Fatal("abc not suspected after maxIdle periods")
+	}
+	if s.isSuspect("def") {
+		t.Error("def suspected despite direct acks")
+	}
+}
+
+func TestMemoBudget(t *testing.T) {
+	s := newTestStateMachine()
+	for i := 0; i < 99; i++ {
+		id := randID()
+		s.receive(packet{
+			Type:     ping,
+			remoteID: id,
+			Msgs:     []*message{{Type: alive, NodeID: id}},
+		})
+	}
+	for _, tt := range []struct{ budget, want int }{
+		{0, s.disseminationFactor()},
+		{3, 3},
+	} {
+		s.memoBudget = tt.budget
+		s.memoSends = 0
+		s.addMemo([]byte("Hello, SWIM!"))
+		for i := 0; i < 20 && s.memoQueue.Len() > 0; i++ {
+			s.flush()
+		}
+		if got := int(s.memoSends); got != tt.want {
+			t.Errorf("budget %v: memo sent %v times, expected %v", tt.budget, got, tt.want)
+		}
+	}
+}
+
+func TestMakePacket(t *testing.T) {
+	s := newTestStateMachine()
+	msgs := []*message{{Type: alive, NodeID: "abc"}}
+	for i := 0; i < 2*s.maxMsgs; i++ {
+		msgs = append(msgs, &message{Type: alive, NodeID: randID()})
+	}
+	s.receive(packet{Type: ping, remoteID: "abc", Msgs: msgs})
+	s.memoBudget = 2
+	s.addMemo([]byte("Hello, SWIM!"))
+
+	// An introductory alive message, a memo, and membership messages
+	// exactly fill a packet to a member not yet contacted
+	dst := msgs[1].NodeID
+	p := s.makePacket(ping, dst, "", netip.AddrPort{})
+	if len(p.Msgs) != s.maxMsgs {
+		t.Fatalf("got %v messages, expected %v", len(p.Msgs), s.maxMsgs)
+	}
+	if m := p.Msgs[0]; m.Type != alive || m.NodeID != s.id {
+		t.Errorf("first message: got %v, expected introductory alive message", m)
+	}
+	if p.Msgs[1].MemoID == "" {
+		t.Errorf("second message: got %v, expected memo", p.Msgs[1])
+	}
+	for _, m := range p.Msgs[2:] {
+		if m.NodeID == s.id || m.MemoID != "" {
+			t.Errorf("got %v, expected membership message", m)
+		}
+	}
+
+	// Once dst has been contacted, membership messages take the place of
+	// the introduction
+	p = s.makePacket(ping, dst, "", netip.AddrPort{})
+	if len(p.Msgs) != s.maxMsgs || p.Msgs[0].MemoID == "" {
+		t.Errorf("got %v, expected a memo and %v membership messages", p.Msgs, s.maxMsgs-1)
+	}
+	p = s.makePacket(ping, dst, "", netip.AddrPort{})
+	if len(p.Msgs) != s.maxMsgs {
+		t.Errorf("got %v messages without a memo, expected %v", len(p.Msgs), s.maxMsgs)
+	}
+
+	// A packet that would exceed the limit panics
+	s.maxMsgs = 1
+	s.addMemo([]byte("Goodbye, SWIM!"))
+	defer func() {
+		if recover() == nil {
+			t.Error("oversized packet did not panic")
+		}
+	}()
+	s.makePacket(ping, msgs[2].NodeID, "", netip.AddrPort{})
+}
+
+func TestPingReqDedup(t *testing.T) {
+	s := newTestStateMachine()
+	srcs := []id{"abc", "def", "ghi"}
+	for _, id := range append(srcs, "xyz") {
+		s.receive(packet{
+			Type:     ping,
+			remoteID: id,
+			Msgs:     []*message{{Type: alive, NodeID: id}},
+		})
+	}
+	var pings int
+	for _, src := range srcs {
+		ps, _ := s.receive(packet{Type: pingReq, remoteID: src, TargetID: "xyz"})
+		for _, p := range ps {
+			if p.Type == ping && p.remoteID == "xyz" {
+				pings++
+			}
+		}
+	}
+	if pings != 1 {
+		t.Errorf("got %v pings to target, expected 1", pings)
+	}
+	ps, _ := s.receive(packet{Type: ack, remoteID: "xyz"})
+	got := make(map[id]bool)
+	for _, p := range ps {
+		if p.Type == ack && p.TargetID == "xyz" {
+			got[p.remoteID] = true
+		}
+	}
+	if !reflect.DeepEqual(got, map[id]bool{"abc": true, "def": true, "ghi": true}) {
+		t.Errorf("acks relayed to %v, expected all requesters", got)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	s := newTestStateMachine()
+	s.receive(packet{
+		Type:     ping,
+		remoteID: "abc",
+		Msgs:     []*message{{Type: alive, NodeID: "abc"}},
+	})
+	s.tick()
+	if len(s.probes) != 1 || s.probes[0].target != "abc" {
+		t.Fatalf("ping targets: got %v, expected [abc]", s.probes)
+	}
+	s.expire()
+	if !s.isSuspect("abc") {
+		t.Fatal("not suspected after probe expired")
+	}
+	// A late ack does not count, and the probe is not concluded twice
+	s.receive(packet{Type: ack, remoteID: "abc"})
+	if s.probes[0].gotAck {
+		t.Error("late ack recorded")
+	}
+	if ps := s.expire(); ps != nil {
+		t.Errorf("second expire: got %v, expected nil", ps)
+	}
+	s.tick()
+	if p := s.members["abc"]; p.misses != 1 {
+		t.Errorf("misses: got %v, expected 1", p.misses)
+	}
+}
+
+func TestPingReqHelpers(t *testing.T) {
+	s := newTestStateMachine()
+	var msgs []*message
+	for _, id := range []id{"tgt", "abc", "def", "ghi", "jkl", "mno"} {
+		msgs = append(msgs, &message{Type: alive, NodeID: id})
+	}
+	s.receive(packet{Type: ping, remoteID: "abc", Msgs: msgs})
+	for _, id := range []id{"ghi", "jkl", "mno"} {
+		s.suspects[id] = 0
+	}
+	s.setProbes("tgt")
+	for i := 0; i < 20; i++ {
+		ps := s.timeout()
+		if len(ps) != 2 {
+			t.Fatalf("got %v ping requests, expected 2", len(ps))
+		}
+		for _, p := range ps {
+			if p.remoteID != "abc" && p.remoteID != "def" {
+				t.Fatalf("ping request sent to %v, expected only unsuspected members", p.remoteID)
+			}
+		}
+	}
+}
+
+func TestProbesPerPeriod(t *testing.T) {
+	var ids []id
+	for i := 0; i < 12; i++ {
+		ids = append(ids, id(fmt.Sprint(i)))
+	}
+	newSM := func(k int) *stateMachine {
+		s := newTestStateMachine()
+		s.probesPerPeriod = k
+		var msgs []*message
+		for _, id := range ids {
+			msgs = append(msgs, &message{Type: alive, NodeID: id})
+		}
+		s.receive(packet{Type: ping, remoteID: ids[0], Msgs: msgs})
+		return s
+	}
+
+	// Every member is probed within len(ids)/k periods
+	for _, k := range []int{1, 3, 5, 12, 20} {
+		s := newSM(k)
+		want := k
+		if want > len(ids) {
+			want = len(ids)
+		}
+		probed := make(map[id]bool)
+		periods := 0
+		for len(probed) < len(ids) && periods < 2*len(ids) {
+			periods++
+			pinged := make(map[id]bool)
+			for _, p := range s.tick() {
+				if p.Type == ping {
+					pinged[p.remoteID] = true
+					probed[p.remoteID] = true
+				}
+			}
+			if len(pinged) != want {
+				t.Errorf("k=%v: pinged %v distinct members, expected %v", k, len(pinged), want)
+			}
+			for _, pr := range s.probes {
+				pr.gotAck = true
+			}
+		}
+		if n := (len(ids) + want - 1) / want; periods != n {
+			t.Errorf("k=%v: probed every member in %v periods, expected %v", k, periods, n)
+		}
+	}
+
+	// Each target that does not ack is probed indirectly and suspected
+	s := newSM(3)
+	s.tick()
+	targets := make(map[id]bool)
+	for _, pr := range s.probes {
+		targets[pr.target] = true
+	}
+	acked := s.probes[0].target
+	s.receive(packet{Type: ack, remoteID: acked})
+	requested := make(map[id]bool)
+	for _, p := range s.timeout() {
+		if targets[p.remoteID] {
+			t.Errorf("ping request sent to ping target %v", p.remoteID)
+		}
+		requested[p.TargetID] = true
+	}
+	if requested[acked] || len(requested) != 2 {
+		t.Errorf("ping requests for %v, expected the two targets other than %v", requested, acked)
+	}
+	s.expire()
+	for target := range targets {
+		if s.isSuspect(target) != (target != acked) {
+			t.Errorf("%v: suspected %v, expected %v", target, s.isSuspect(target), target != acked)
+		}
+	}
+}
+
+func TestSuspicionOrigin(t *testing.T) {
+	s := newTestStateMachine()
+	var msgs []*message
+	for _, id := range []id{"abc", "def", "ghi", "jkl", "mno"} {
+		msgs = append(msgs, &message{Type: alive, NodeID: id})
+	}
+	s.receive(packet{Type: ping, remoteID: "abc", Msgs: msgs})
+
+	// Suspicion originated by s
+	s.setProbes("jkl")
+	ps := s.expire()
+	if len(ps) != 1 || ps[0].Msgs[0].Origin != s.id {
+		t.Errorf("expire: got %v, expected suspicion originated by s", ps)
+	}
+
+	// Suspicion relayed by s
+	s.receive(packet{Type: gossip, remoteID: "def", Msgs: []*message{{Type: suspected, NodeID: "ghi", Origin: "abc"}}})
+	if origin := s.memberMessage("ghi").Origin; origin != "abc" {
+		t.Errorf("relayed suspicion: got origin %v, expected abc", origin)
+	}
+
+	// Suspicion that s then confirms with its own probe is reported as s's
+	s.setProbes("ghi")
+	s.concluded = false
+	ps = s.expire()
+	if len(ps) != 1 || ps[0].Msgs[0].Origin != s.id {
+		t.Errorf("expire of suspect: got %v, expected suspicion originated by s", ps)
+	}
+
+	// Suspicion of s is refuted to its originator and sender first
+	ps, _ = s.receive(packet{Type: gossip, remoteID: "def", Msgs: []*message{{Type: suspected, NodeID: s.id, Origin: "abc"}}})
+	got := make(map[id]bool)
+	for _, p := range ps {
+		if len(p.Msgs) == 1 && p.Msgs[0].Type == alive && p.Msgs[0].NodeID == s.id && p.Msgs[0].Incarnation == 1 {
+			got[p.remoteID] = true
+		}
+	}
+	if len(got) != refuteFanout || !got["abc"] || !got["def"] {
+		t.Errorf("refutations sent to %v, expected abc, def, and %v others", got, refuteFanout-2)
+	}
+}
+
+func TestRefutationOutsideReceive(t *testing.T) {
+	s := newTestStateMachine()
+	s.receive(packet{Type: ping, remoteID: "abc", Msgs: []*message{{Type: alive, NodeID: "abc"}}})
+	refuted, ok := s.processMsg(&message{Type: suspected, NodeID: s.id, Origin: "abc"})
+	if !refuted || !ok || s.incarnation != 1 {
+		t.Errorf("processMsg: got %v, %v, incarnation %v; expected true, true, 1", refuted, ok, s.incarnation)
+	}
+	// A later packet does not send the refutation again
+	ps, _ := s.receive(packet{Type: ping, remoteID: "abc"})
+	for _, p := range ps {
+		if p.Type == gossip {
+			t.Errorf("unrelated ping: got refutation %v", p)
+		}
+	}
+}
+
+func TestPeerStats(t *testing.T) {
+	s := newTestStateMachine()
+	s.receive(packet{
+		Type:     ping,
+		remoteID: "abc",
+		Msgs: []*message{
+			{Type: alive, NodeID: "abc"},
+			{Type: alive, NodeID: "def"},
+		},
+	})
+	s.tick()
+	target := s.probes[0].target
+	s.receive(packet{Type: ack, remoteID: target})
+	s.receive(packet{Type: ack, remoteID: target})
+	for s.tick(); s.probes[0].target != target; s.tick() {
+	}
+	s.timeout()
+	helper := id("abc")
+	if target == helper {
+		helper = "def"
+	}
+	s.receive(packet{Type: ack, remoteID: helper, TargetID: target})
+	want := PeerStats{
+		PingsSent:       2,
+		AcksReceived:    1,
+		DirectTimeouts:  1,
+		IndirectRescues: 1,
+	}
+	if got := s.members[target].stats; got != want {
+		t.Errorf("got %+v, expected %+v", got, want)
+	}
+
+	s.receive(packet{Type: gossip, remoteID: helper, Msgs: []*message{{Type: alive, NodeID: target, Incarnation: 1}}})
+	if got := s.members[target].stats; got != (PeerStats{}) {
+		t.Errorf("after new incarnation: got %+v, expected zero", got)
+	}
+}
+
+func TestProbeSeq(t *testing.T) {
+	s := newTestStateMachine()
+	s.receive(packet{Type: ping, remoteID: "abc", Msgs: []*message{{Type: alive, NodeID: "abc"}}})
+	if ps, _ := s.receive(packet{Type: ping, remoteID: "abc", ProbeSeq: 7}); len(ps) != 1 || ps[0].ProbeSeq != 7 {
+		t.Fatalf("ack to ping: got %v, expected probe sequence number 7", ps)
+	}
+
+	ps := s.tick()
+	first := ps[len(ps)-1].ProbeSeq
+	ps = s.tick()
+	if seq := ps[len(ps)-1].ProbeSeq; seq == first {
+		t.Fatalf("consecutive probes both numbered %v", seq)
+	}
+
+	// A late ack to the first probe does not satisfy the second
+	s.receive(packet{Type: ack, remoteID: "abc", ProbeSeq: first})
+	if s.probes[0].gotAck {
+		t.Error("late ack accepted")
+	}
+	s.receive(packet{Type: ack, remoteID: "abc", ProbeSeq: s.probeSeq})
+	if !s.probes[0].gotAck {
+		t.Error("current ack not accepted")
+	}
+
+	// Acks from nodes that do not number probes are accepted
+	s.tick()
+	s.receive(packet{Type: ack, remoteID: "abc"})
+	if !s.probes[0].gotAck {
+		t.Error("unnumbered ack not accepted")
+	}
+}
+
+func TestLeave(t *testing.T) {
+	s := newTestStateMachine()
+	s.receive(packet{
+		Type:     ping,
+		remoteID: "abc",
+		Msgs: []*message{
+			{Type: alive, NodeID: "abc"},
+			{Type: alive, NodeID: "def"},
+		},
+	})
+	s.drain()
+	s.setProbes("abc")
+	if ps := s.timeout(); ps != nil {
+		t.Errorf("timeout: got %v, expected no ping requests", ps)
+	}
+	if ps := s.expire(); ps != nil || s.isSuspect("abc") {
+		t.Errorf("expire: got %v, expected no suspicion", ps)
+	}
+	if ps, _ := s.receive(packet{Type: pingReq, remoteID: "def", TargetID: "abc"}); ps != nil {
+		t.Errorf("ping request: got %v, expected no ping", ps)
+	}
+
+	s.leave()
+	if !s.isAnnouncing() {
+		t.Fatal("departure not queued")
+	}
+	s.receive(packet{
+		Type:     ping,
+		remoteID: "abc",
+		Msgs:     []*message{{Type: suspected, NodeID: s.id, Incarnation: s.incarnation}},
+	})
+	if s.incarnation != 0 {
+		t.Error("refuted suspicion after leaving")
+	}
+	var m *message
+	s.msgQueue.Range(func(key id, value *message, _ int) bool {
+		if key == s.id {
+			m = value
+		}
+		return true
+	})
+	if m == nil || m.Type != failed || m.Reason != Left {
+		t.Fatalf("departure: got %+v, expected failed message with reason Left", m)
+	}
+
+	var reason FailReason
+	peer := newStateMachine(
+		func(id, netip.AddrPort) {},
+		func(id, netip.AddrPort, []byte) {},
+		func(_ id, r FailReason) { reason = r },
+	)
+	peer.receive(packet{Type: ping, remoteID: s.id, Msgs: []*message{s.aliveMessage()}})
+	peer.receive(packet{Type: ping, remoteID: s.id, Msgs: []*message{m}})
+	if peer.isMember(s.id) || reason != Left {
+		t.Errorf("peer: member %v, reason %v; expected removal with reason Left", peer.isMember(s.id), reason)
+	}
+}
+
+func TestNonMemberPackets(t *testing.T) {
+	s := newTestStateMachine()
+	s.receive(packet{Type: ping, remoteID: "abc", Msgs: []*message{{Type: alive, NodeID: "abc"}}})
+	addr := netip.MustParseAddrPort("127.0.0.1:1000")
+
+	// A ping from a sender whose introduction was lost is acknowledged at
+	// the address it was sent from
+	ps, _ := s.receive(packet{Type: ping, remoteID: "xyz", remoteAddr: addr, ProbeSeq: 7})
+	if len(ps) != 1 || ps[0].Type != ack || ps[0].remoteAddr != addr || ps[0].ProbeSeq != 7 {
+		t.Errorf("ping from non-member: got %v, expected an ack to %v", ps, addr)
+	} else if len(ps[0].Msgs) != 0 {
+		t.Errorf("ack to non-member: got messages %v, expected none", ps[0].Msgs)
+	}
+	if s.isMember("xyz") {
+		t.Error("non-member added by its ping")
+	}
+
+	// Only an observer is told of s's members
+	ps, _ = s.receive(packet{Type: ping, remoteID: "obs", remoteAddr: addr, Observer: true})
+	var ids []id
+	for _, p := range ps {
+		for _, m := range p.Msgs {
+			ids = append(ids, m.NodeID)
+		}
+	}
+	if len(ids) != 2 || !containsID(ids, s.id) || !containsID(ids, "abc") {
+		t.Errorf("ack to observer: got news of %v, expected %v and abc", ids, s.id)
+	}
+
+	// Ping requests from non-members, or for targets s has yet to learn
+	// of, are not relayed
+	if ps, _ := s.receive(packet{Type: pingReq, remoteID: "xyz", remoteAddr: addr, TargetID: "abc"}); len(ps) != 0 {
+		t.Errorf("ping request from non-member: got %v, expected none", ps)
+	}
+	if ps, _ := s.receive(packet{Type: pingReq, remoteID: "abc", TargetID: "xyz"}); len(ps) != 0 {
+		t.Errorf("ping request for non-member: got %v, expected none", ps)
+	}
+
+	// An ack is not relayed to a requester removed since its request
+	s.receive(packet{Type: ping, remoteID: "def", Msgs: []*message{{Type: alive, NodeID: "def"}}})
+	s.receive(packet{Type: pingReq, remoteID: "def", TargetID: "abc"})
+	s.remove("def", Failed)
+	if ps, _ := s.receive(packet{Type: ack, remoteID: "abc"}); len(ps) != 0 {
+		t.Errorf("ack relayed to removed requester: %v", ps)
+	}
+}
+
+func TestAddresslessMember(t *testing.T) {
+	s := newTestStateMachine()
+	relay := netip.MustParseAddrPort("192.0.2.1:7946")
+	real := netip.MustParseAddrPort("192.0.2.2:7946")
+	addr := func() netip.AddrPort { return s.members["xyz"].addr }
+
+	// A relayed message without an address does not take the relay's
+	s.receive(packet{
+		Type:       ping,
+		remoteID:   "abc",
+		remoteAddr: relay,
+		Msgs: []*message{
+			{Type: alive, NodeID: "abc"},
+			{Type: suspected, NodeID: "xyz"},
+		},
+	})
+	if !s.isMember("xyz") || addr().IsValid() {
+		t.Fatalf("address-less suspect: member %v, address %v; expected member without address", s.isMember("xyz"), addr())
+	}
+	if got := s.members["abc"].addr; got != relay {
+		t.Errorf("relay address: got %v, expected %v", got, relay)
+	}
+
+	// A packet from the member fills in its address
+	s.receive(packet{Type: ack, remoteID: "xyz", remoteAddr: real})
+	if addr() != real {
+		t.Errorf("after packet from member: got address %v, expected %v", addr(), real)
+	}
+
+	// Address-less news does not erase the known address
+	s.receive(packet{
+		Type:       ping,
+		remoteID:   "abc",
+		remoteAddr: relay,
+		Msgs:       []*message{{Type: alive, NodeID: "xyz", Incarnation: 1}},
+	})
+	if addr() != real {
+		t.Errorf("after address-less alive: got address %v, expected %v", addr(), real)
+	}
+}
+
+func TestUpdateStatusKeepsAddr(t *testing.T) {
+	s := newTestStateMachine()
+	addr := netip.MustParseAddrPort("192.0.2.1:7946")
+	s.updateStatus(&message{Type: alive, NodeID: "abc", Addr: addr})
+	for _, m := range []*message{
+		{Type: alive, NodeID: "abc", Incarnation: 1},
+		{Type: suspected, NodeID: "abc", Incarnation: 1},
+		{Type: alive, NodeID: "abc", Incarnation: 2},
+	} {
+		s.updateStatus(m)
+		if got := s.members["abc"].addr; got != addr {
+			t.Fatalf("after %v message with incarnation %v and no address: got address %v, expected %v",
+				m.Type, m.Incarnation, got, addr)
+		}
+	}
+	if got := s.members["abc"].incarnation; got != 2 {
+		t.Errorf("incarnation: got %v, expected 2", got)
+	}
+}
+
+func TestReintroduce(t *testing.T) {
+	s := newTestStateMachine()
+	introduces := func(ps []packet) bool {
+		for _, p := range ps {
+			for _, m := range p.Msgs {
+				if m.NodeID == s.id && m.Type == alive {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	ps, _ := s.receive(packet{
+		Type:     ping,
+		remoteID: "abc",
+		Msgs:     []*message{{Type: alive, NodeID: "abc", Incarnation: 3}},
+	})
+	if !introduces(ps) {
+		t.Fatalf("first contact: got %v, expected introduction", ps)
+	}
+	if ps, _ := s.receive(packet{Type: ping, remoteID: "abc"}); introduces(ps) {
+		t.Errorf("later contact: got %v, expected no introduction", ps)
+	}
+
+	// abc restarts with the same ID
+	ps, _ = s.receive(packet{
+		Type:     ping,
+		remoteID: "abc",
+		Msgs:     []*message{{Type: alive, NodeID: "abc"}},
+	})
+	if !introduces(ps) {
+		t.Errorf("after restart: got %v, expected introduction", ps)
+	}
+}
+
+func TestMaxMembers(t *testing.T) {
+	s := newTestStateMachine()
+	s.maxMembers = 3
+	s.receive(packet{
+		Type:     ping,
+		remoteID: "abc",
+		Msgs: []*message{
+			{Type: alive, NodeID: "abc"},
+			{Type: alive, NodeID: "def"},
+			{Type: alive, NodeID: "ghi"},
+			{Type: alive, NodeID: "abc", Incarnation: 1},
+		},
+	})
+	if !s.isMember("abc") || !s.isMember("def") || s.isMember("ghi") {
+		t.Fatalf("members: got %v, expected abc and def", s.members)
+	}
+	if s.members["abc"].incarnation != 1 {
+		t.Error("update of existing member ignored at capacity")
+	}
+	if s.rejectedJoins != 1 {
+		t.Errorf("rejected joins: got %v, expected 1", s.rejectedJoins)
+	}
+	s.msgQueue.Range(func(key id, _ *message, _ int) bool {
+		if key == "ghi" {
+			t.Error("rejected member queued for dissemination")
+		}
+		return true
+	})
+
+	s.receive(packet{Type: ping, remoteID: "abc", Msgs: []*message{{Type: failed, NodeID: "def"}}})
+	s.receive(packet{Type: ping, remoteID: "abc", Msgs: []*message{{Type: alive, NodeID: "ghi"}}})
+	if !s.isMember("ghi") {
+		t.Error("new member not admitted below capacity")
+	}
+}
+
+func TestJoinFilter(t *testing.T) {
+	s := newTestStateMachine()
+	var calls int
+	s.joinFilter = func(id id, _ netip.AddrPort) bool {
+		calls++
+		return id != "def"
+	}
+	s.receive(packet{
+		Type:     ping,
+		remoteID: "abc",
+		Msgs: []*message{
+			{Type: alive, NodeID: "abc"},
+			{Type: alive, NodeID: "def"},
+			{Type: alive, NodeID: "abc", Incarnation: 1},
+		},
+	})
+	if !s.isMember("abc") || s.isMember("def") {
+		t.Fatalf("members: got %v, expected abc", s.members)
+	}
+	if calls != 2 {
+		t.Errorf("filter called %v times, expected 2", calls)
+	}
+	if s.rejectedJoins != 1 {
+		t.Errorf("rejected joins: got %v, expected 1", s.rejectedJoins)
+	}
+	s.msgQueue.Range(func(key id, _ *message, _ int) bool {
+		if key == "def" {
+			t.Error("rejected member queued for dissemination")
+		}
+		return true
+	})
+}
+
+func TestQuarantine(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		removed removedSet
+	}{
+		{"map", make(removedMap)},
+		{"filter", newRemovedFilter(100, 0.01)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStateMachine()
+			s.removed = tt.removed
+			now := time.Now()
+			s.now = func() time.Time { return now }
+			s.receive(packet{
+				Type:     ping,
+				remoteID: "abc",
+				Msgs: []*message{
+					{Type: alive, NodeID: "abc"},
+					{Type: alive, NodeID: "def"},
+				},
+			})
+			s.remove("abc", Failed)
+			s.remove("def", Failed)
+
+			rejoin := packet{Type: ping, remoteID: "abc", Msgs: []*message{{Type: alive, NodeID: "abc", Incarnation: 1}}}
+			now = now.Add(s.quarantine - time.Second)
+			if ps, _ := s.receive(rejoin); len(ps) != 0 || s.isMember("abc") {
+				t.Fatal("removed sender not ignored during quarantine")
+			}
+			now = now.Add(time.Second)
+			if ps, _ := s.receive(rejoin); len(ps) == 0 || !s.isMember("abc") {
+				t.Fatal("removed sender not readmitted after quarantine")
+			}
+
+			// News of other removed ids is still ignored
+			s.receive(packet{Type: ping, remoteID: "abc", Msgs: []*message{{Type: alive, NodeID: "def", Incarnation: 1}}})
+			if s.isMember("def") {
+				t.Error("removed id readmitted by gossip")
+			}
+		})
+	}
+}
+
+func TestRejoin(t *testing.T) {
+	s := newTestStateMachine()
+	seed := netip.MustParseAddrPort("127.0.0.1:1000")
+	s.seeds = []netip.AddrPort{seed}
+	s.rejoinGrace = 2
+	rejoins := func(periods int) []int {
+		var got []int
+		for i := 0; i < periods; i++ {
+			for _, p := range s.tick() {
+				if p.remoteAddr == seed {
+					got = append(got, i)
+				}
+			}
+		}
+		return got
+	}
+
+	// A state machine that has never had a member does not rejoin
+	if got := rejoins(5); len(got) != 0 {
+		t.Errorf("rejoined before joining, in periods %v", got)
+	}
+
+	s.receive(packet{Type: ping, remoteID: "abc", Msgs: []*message{{Type: alive, NodeID: "abc"}}})
+	s.remove("abc", Failed)
+	got := rejoins(70)
+	want := []int{2, 3, 5, 9, 17, 33, 65}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rejoined in periods %v, expected %v", got, want)
+	}
+	if _, ok := s.removed.when("abc"); ok {
+		t.Error("isolated state machine did not forget removed member")
+	}
+
+	// Rejoining stops once s has a member
+	s.receive(packet{Type: ack, remoteID: "def", Msgs: []*message{{Type: alive, NodeID: "def"}}})
+	if got := rejoins(3); len(got) != 0 || s.isolated {
+		t.Errorf("rejoined with a member, in periods %v", got)
+	}
+}
+
+func TestUpdateAddr(t *testing.T) {
+	newMachine := func() *stateMachine {
+		return newTestStateMachine()
+	}
+	s, peer := newMachine(), newMachine()
+	oldAddr := netip.MustParseAddrPort("127.0.0.1:1000")
+	newAddr := netip.MustParseAddrPort("127.0.0.2:2000")
+	peer.receive(packet{Type: ping, remoteID: s.id, remoteAddr: oldAddr, Msgs: []*message{s.aliveMessage()}})
+	peer.receive(packet{Type: ping, remoteID: "abc", remoteAddr: netip.MustParseAddrPort("127.0.0.1:3000"), Msgs: []*message{{Type: alive, NodeID: "abc"}}})
+
+	s.updateAddr(newAddr)
+	if s.incarnation != 1 {
+		t.Errorf("incarnation: got %v, expected 1", s.incarnation)
+	}
+	var news *message
+	s.msgQueue.Range(func(key id, m *message, _ int) bool {
+		if key == s.id {
+			news = m
+		}
+		return news == nil
+	})
+	if news == nil || news.Type != alive || news.Addr != newAddr {
+		t.Fatalf("queued news of s: got %+v, expected alive at %v", news, newAddr)
+	}
+
+	// The peer learns of the new address from another member
+	peer.receive(packet{Type: gossip, remoteID: "abc", Msgs: []*message{news}})
+	if got := peer.members[s.id].addr; got != newAddr {
+		t.Errorf("peer has s at %v, expected %v", got, newAddr)
+	}
+	// and keeps it when s sends from its local address
+	peer.receive(packet{Type: ping, remoteID: s.id, remoteAddr: oldAddr, Msgs: []*message{s.aliveMessage()}})
+	if got := peer.members[s.id].addr; got != newAddr {
+		t.Errorf("after direct contact, peer has s at %v, expected %v", got, newAddr)
+	}
+}
+
+func TestKnownSendersOnly(t *testing.T) {
+	s := newTestStateMachine()
+	seed := netip.MustParseAddrPort("192.0.2.1:7946")
+	stranger := netip.MustParseAddrPort("192.0.2.2:7946")
+	s.trusted = make(map[netip.AddrPort]bool)
+	s.trust(seed)
+
+	ps, _ := s.receive(packet{Type: ping, remoteID: "xyz", remoteAddr: stranger, Msgs: []*message{{Type: alive, NodeID: "xyz"}}})
+	if len(ps) != 0 || s.isMember("xyz") || s.strangers != 1 {
+		t.Fatalf("stranger's packet not ignored: got %v, %v strangers", ps, s.strangers)
+	}
+
+	// A seed, at an IPv4-mapped address, and members it introduces are
+	// accepted
+	mapped := netip.AddrPortFrom(netip.AddrFrom16(seed.Addr().As16()), seed.Port())
+	ps, _ = s.receive(packet{
+		Type:       ping,
+		remoteID:   "abc",
+		remoteAddr: mapped,
+		Msgs: []*message{
+			{Type: alive, NodeID: "abc"},
+			{Type: alive, NodeID: "def", Addr: stranger},
+		},
+	})
+	if len(ps) != 1 || !s.isMember("abc") || !s.isMember("def") {
+		t.Fatalf("seed's packet not accepted: got %v, members %v", ps, s.members)
+	}
+	if ps, _ := s.receive(packet{Type: ping, remoteID: "def", remoteAddr: stranger}); len(ps) != 1 {
+		t.Errorf("member's packet not accepted: got %v", ps)
+	}
+	if s.strangers != 1 {
+		t.Errorf("strangers: got %v, expected 1", s.strangers)
+	}
+}
+
+func TestMessageUrgency(t *testing.T) {
+	s := newTestStateMachine()
+	for _, m := range []*message{
+		{Type: alive, NodeID: "aaa"},
+		{Type: alive, NodeID: "bbb"},
+		{Type: suspected, NodeID: "ccc"},
+		{Type: alive, NodeID: "ddd"},
+		{Type: failed, NodeID: "eee"},
+	} {
+		s.msgQueue.Upsert(m.NodeID, m)
+	}
+	var types []msgType
+	for _, m := range s.msgQueue.PopN(3) {
+		types = append(types, m.Type)
+	}
+	if !reflect.DeepEqual(types, []msgType{failed, suspected, alive}) {
+		t.Errorf("got message types %v, expected failed, suspected, alive", types)
+	}
+}
@@ -0,0 +1,127 @@
+package swim
+
+import "sync"
+
+// An orderedDispatcher dispatches the handler calls that report each peer's
+// updates so that they happen in the documented order: for each peer, calls
+// reporting its memos begin after the call reporting its join returns, and
+// the call reporting its failure begins after those calls return. Calls
+// concerning different peers are not ordered with respect to one another.
+// The methods of an orderedDispatcher must not be called concurrently.
+type orderedDispatcher struct {
+	dispatch func(f func())
+	peers    map[id]*peerCalls
+}
+
+// peerCalls tracks the outstanding handler calls concerning a peer.
+type peerCalls struct {
+	join sync.WaitGroup
+	memo sync.WaitGroup
+}
+
+// newOrderedDispatcher returns an orderedDispatcher that arranges for
+// functions to be called using dispatch.
+func newOrderedDispatcher(dispatch func(f func())) *orderedDispatcher {
+	return &orderedDispatcher{
+		dispatch: dispatch,
+		peers:    make(map[id]*peerCalls),
+	}
+}
+
+// join dispatches f, which reports that the peer id joined.
+func (d *orderedDispatcher) join(id id, f func()) {
+	pc := &peerCalls{}
+	d.peers[id] = pc
+	pc.join.Add(1)
+	d.dispatch(func() {
+		defer pc.join.Done()
+		f()
+	})
+}
+
+// memo dispatches f, which reports a memo from the peer id, to be called
+// once the call reporting the peer's join returns.
+func (d *orderedDispatcher) memo(id id, f func()) {
+	pc := d.peer(id)
+	pc.memo.Add(1)
+	d.dispatch(func() {
+		defer pc.memo.Done()
+		pc.join.Wait()
+		f()
+	})
+}
+
+// fail dispatches f, which reports that the peer id left, to be called once
+// the calls reporting the peer's join and memos return. A later join by the
+// same id is ordered independently.
+func (d *orderedDispatcher) fail(id id, f func()) {
+	pc := d.peer(id)
+	delete(d.peers, id)
+	d.dispatch(func() {
+		pc.join.Wait()
+		pc.memo.Wait()
+		f()
+	})
+}
+
+// peer returns the calls outstanding for id, tracking id if it is not already
+// tracked. A peer whose join was never reported has no join call to wait for.
+func (d *orderedDispatcher) peer(id id) *peerCalls {
+	pc, ok := d.peers[id]
+	if !ok {
+		pc = &peerCalls{}
+		d.peers[id] = pc
+	}
+	return pc
+}
+
+// A handlerQueue is an unbounded FIFO queue of pending handler calls. Pushing
+// never blocks, so a Node can queue calls while holding its lock without
+// waiting for handlers that may themselves need the lock.
+type handlerQueue struct {
+	mu     sync.Mutex
+	cond   sync.Cond
+	calls  []func()
+	closed bool
+}
+
+// newHandlerQueue returns an empty handlerQueue.
+func newHandlerQueue() *handlerQueue {
+	q := &handlerQueue{}
+	q.cond.L = &q.mu
+	return q
+}
+
+// push appends f to q.
+func (q *handlerQueue) push(f func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.calls = append(q.calls, f)
+	q.cond.Signal()
+}
+
+// pop removes and returns the first call in q, waiting for one to be pushed
+// if q is empty. Once q is closed and empty, pop returns false.
+func (q *handlerQueue) pop() (func(), bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.calls) == 0 {
+		if q.closed {
+			return nil, false
+		}
+		q.cond.Wait()
+	}
+	f := q.calls[0]
+	q.calls[0] = nil
+	q.calls = q.calls[1:]
+	return f, true
+}
+
+// close causes pop to return false once the calls already in q have been
+// removed.
+func (q *handlerQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
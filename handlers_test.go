@@ -0,0 +1,92 @@
+package swim
+
+import (
+	"testing"
+	"time"
+)
+
+// called returns a function that sends name on ch after receiving from
+// release, if release is not nil.
+func called(ch chan<- string, name string, release <-chan struct{}) func() {
+	return func() {
+		if release != nil {
+			<-release
+		}
+		ch <- name
+	}
+}
+
+// expectCalls checks that the calls reported on ch are want, in order, and
+// that no others follow promptly.
+func expectCalls(t *testing.T, ch <-chan string, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case got := <-ch:
+			if got != w {
+				t.Fatalf("got call %v, expected %v", got, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for call %v", w)
+		}
+	}
+	select {
+	case got := <-ch:
+		t.Fatalf("unexpected call %v", got)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestOrderedDispatcher(t *testing.T) {
+	ch := make(chan string, 10)
+	d := newOrderedDispatcher(func(f func()) { go f() })
+
+	// Memos and failure wait for a slow join handler
+	release := make(chan struct{})
+	d.join("abc", called(ch, "join", release))
+	d.memo("abc", called(ch, "memo", nil))
+	d.fail("abc", called(ch, "fail", nil))
+	expectCalls(t, ch)
+	close(release)
+	expectCalls(t, ch, "join", "memo", "fail")
+
+	// Failure waits for a slow join handler even without memos
+	release = make(chan struct{})
+	d.join("def", called(ch, "join", release))
+	d.fail("def", called(ch, "fail", nil))
+	expectCalls(t, ch)
+	close(release)
+	expectCalls(t, ch, "join", "fail")
+
+	// Failure waits for slow memo handlers
+	release = make(chan struct{})
+	d.join("ghi", called(ch, "join", nil))
+	expectCalls(t, ch, "join")
+	d.memo("ghi", called(ch, "memo", release))
+	d.fail("ghi", called(ch, "fail", nil))
+	expectCalls(t, ch)
+	close(release)
+	expectCalls(t, ch, "memo", "fail")
+
+	// A peer that rejoins is not held up by its earlier failure
+	release = make(chan struct{})
+	d.join("jkl", called(ch, "join", nil))
+	expectCalls(t, ch, "join")
+	d.memo("jkl", called(ch, "memo", release))
+	d.fail("jkl", called(ch, "fail", nil))
+	d.join("jkl", called(ch, "rejoin", nil))
+	expectCalls(t, ch, "rejoin")
+	close(release)
+	expectCalls(t, ch, "memo", "fail")
+
+	// Memos and failures of peers whose joins were not reported do not
+	// wait
+	d.memo("mno", called(ch, "memo", nil))
+	expectCalls(t, ch, "memo")
+	d.fail("pqr", called(ch, "fail", nil))
+	expectCalls(t, ch, "fail")
+	// Only the peers that have not failed are tracked
+	if _, ok := d.peers["pqr"]; ok || len(d.peers) != 2 {
+		t.Errorf("tracking %v peers, expected jkl and mno", len(d.peers))
+	}
+}
@@ -3,14 +3,86 @@ package swim
 import (
 	"crypto/rand"
 	"encoding/base32"
+	"hash/fnv"
+	"io"
+	"sort"
 )
 
+// An id identifies a node on the network. It is a random base32 string, and
+// is encoded in full as text and on the wire.
 type id string
 
+const (
+	// shortIDLen is the length of the prefix of an id that String returns.
+	shortIDLen = 8
+
+	// defaultIDBytes is the number of random bytes in an id by default.
+	defaultIDBytes = 15
+
+	// minIDBytes and maxIDBytes bound the number of random bytes in an id.
+	minIDBytes = 5
+	maxIDBytes = 40
+)
+
+// idEncoding encodes ids. Ids of the default length, a multiple of 5 bytes,
+// need no padding.
+var idEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
 func randID() id {
-	b := make([]byte, 15)
-	if _, err := rand.Read(b); err != nil {
+	return randIDLen(defaultIDBytes)
+}
+
+// randIDLen returns a random id encoding n random bytes.
+func randIDLen(n int) id {
+	return readID(rand.Reader, n)
+}
+
+// readID returns an id encoding n bytes read from r.
+func readID(r io.Reader, n int) id {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
 		panic(err)
 	}
-	return id(base32.StdEncoding.EncodeToString(b))
+	return id(idEncoding.EncodeToString(b))
+}
+
+// sortedIDs returns the keys of m in ascending order. A state machine ranges
+// over its maps in this order wherever the order affects its output, so that
+// its behavior depends only on its inputs, clock, and source of randomness.
+func sortedIDs[V any](m map[id]V) []id {
+	ids := make([]id, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// seed returns a seed for a source of random numbers derived from i, so that
+// nodes with different ids draw different sequences of random numbers.
+func (i id) seed() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(i))
+	return int64(h.Sum64())
+}
+
+// String returns a short prefix of i, which suffices to distinguish nodes in
+// logs. Use string(i) for the full value.
+func (i id) String() string {
+	if len(i) > shortIDLen {
+		return string(i[:shortIDLen])
+	}
+	return string(i)
+}
+
+// MarshalText implements encoding.TextMarshaler. It returns the full value of
+// i.
+func (i id) MarshalText() ([]byte, error) {
+	return []byte(i), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (i *id) UnmarshalText(b []byte) error {
+	*i = id(b)
+	return nil
 }
@@ -0,0 +1,63 @@
+package swim
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"kr.dev/diff"
+)
+
+func TestIDString(t *testing.T) {
+	for _, tt := range []struct {
+		id   id
+		want string
+	}{
+		{"", ""},
+		{"ABC", "ABC"},
+		{"ABCDEFGH", "ABCDEFGH"},
+		{"ABCDEFGHIJKLMNOPQRSTUVWX", "ABCDEFGH"},
+	} {
+		diff.Test(t, t.Errorf, fmt.Sprint(tt.id), tt.want)
+	}
+}
+
+func TestIDWireFormat(t *testing.T) {
+	i := randID()
+	b, err := json.Marshal(envelope{SrcID: i})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw struct{ SrcID string }
+	if err := json.Unmarshal(b, &raw); err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, raw.SrcID, string(i))
+
+	var e envelope
+	if err := json.Unmarshal(b, &e); err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, e.SrcID, i)
+}
+
+func TestIDLength(t *testing.T) {
+	diff.Test(t, t.Errorf, len(randID()), 24)
+	for _, bytes := range []int{minIDBytes - 1, maxIDBytes + 1} {
+		if _, err := Start("", WithIDLength(bytes)); err == nil {
+			t.Errorf("Start with ID length %v: got nil error", bytes)
+		}
+	}
+	for _, tt := range []struct{ bytes, want int }{
+		{5, 8},
+		{16, 26},
+		{40, 64},
+	} {
+		n, err := Start("", WithIDLength(tt.bytes))
+		if err != nil {
+			t.Fatal(err)
+		}
+		diff.Test(t, t.Errorf, len(n.ID()), tt.want)
+		n.Shutdown()
+	}
+}
@@ -10,10 +10,21 @@ import "math/rand"
 
 // An Order holds values to return in a randomized round-based sequence such
 // that each value is returned once per round. The sequence is shuffled after
-// each round. The zero value of type Order is an empty Order ready for use.
+// each round. The zero value of type Order is an empty Order ready for use,
+// which uses the default Source of package math/rand.
 type Order[T comparable] struct {
 	a    []T
 	next int
+	rand *rand.Rand // nil for the default Source
+}
+
+// SetRand sets the source of random numbers the Order uses to shuffle and
+// insert values. An Order with a seeded source produces a reproducible
+// sequence. If r is nil, the Order uses the default Source of package
+// math/rand. Because a rand.Rand is not safe for concurrent use, r should not
+// be shared with other goroutines.
+func (o *Order[T]) SetRand(r *rand.Rand) {
+	o.rand = r
 }
 
 // Next returns the next value in the Order, shuffling first if necessary. If
@@ -25,7 +36,7 @@ func (o *Order[T]) Next() T {
 	}
 	if o.next == len(o.a) {
 		o.next = 0
-		rand.Shuffle(len(o.a), o.swap)
+		o.shuffle()
 	}
 	t = o.a[o.next]
 	o.next++
@@ -35,7 +46,7 @@ func (o *Order[T]) Next() T {
 // Add inserts t into a random position in the Order. Depending on where it is
 // inserted, t may or may not be returned in the current round.
 func (o *Order[T]) Add(t T) {
-	o.addAt(t, rand.Intn(len(o.a)+1))
+	o.addAt(t, o.intn(len(o.a)+1))
 }
 
 // addAt inserts t at index k, which must be in the range [0, len(o.a)].
@@ -72,14 +83,27 @@ func (o *Order[T]) removeAt(k int) {
 	o.a = o.a[:last]
 }
 
+// Clear removes all values from the Order. The Order continues to use the
+// same source of random numbers.
+func (o *Order[T]) Clear() {
+	o.a = nil
+	o.next = 0
+}
+
 // IndependentSample returns a slice of unique elements besides exclude, chosen
 // at random. If there are at least n such elements, IndependentSample returns
 // n of them, or else all of them.
 func (o *Order[T]) IndependentSample(n int, exclude T) []T {
+	return o.IndependentSampleFunc(n, func(t T) bool { return t == exclude })
+}
+
+// IndependentSampleFunc is like IndependentSample, but excludes the elements
+// for which exclude returns true.
+func (o *Order[T]) IndependentSampleFunc(n int, exclude func(T) bool) []T {
 	var ts []T
-	for _, i := range rand.Perm(len(o.a)) {
+	for _, i := range o.perm(len(o.a)) {
 		t := o.a[i]
-		if t == exclude {
+		if exclude(t) {
 			continue
 		}
 		ts = append(ts, t)
@@ -93,3 +117,25 @@ func (o *Order[T]) IndependentSample(n int, exclude T) []T {
 func (o *Order[T]) swap(i, j int) {
 	o.a[i], o.a[j] = o.a[j], o.a[i]
 }
+
+func (o *Order[T]) shuffle() {
+	if o.rand == nil {
+		rand.Shuffle(len(o.a), o.swap)
+	} else {
+		o.rand.Shuffle(len(o.a), o.swap)
+	}
+}
+
+func (o *Order[T]) intn(n int) int {
+	if o.rand == nil {
+		return rand.Intn(n)
+	}
+	return o.rand.Intn(n)
+}
+
+func (o *Order[T]) perm(n int) []int {
+	if o.rand == nil {
+		return rand.Perm(n)
+	}
+	return o.rand.Perm(n)
+}
@@ -1,6 +1,7 @@
 package roundrobinrandom
 
 import (
+	"math/rand"
 	"reflect"
 	"testing"
 )
@@ -14,50 +15,50 @@ var addTests = []struct {
 		new(Order[string]),
 		"a",
 		[]*Order[string]{
-			{[]string{"a"}, 0},
+			{[]string{"a"}, 0, nil},
 		},
 	},
 	{
-		&Order[string]{[]string{"a"}, 0},
+		&Order[string]{[]string{"a"}, 0, nil},
 		"b",
 		[]*Order[string]{
-			{[]string{"b", "a"}, 0},
-			{[]string{"a", "b"}, 0},
+			{[]string{"b", "a"}, 0, nil},
+			{[]string{"a", "b"}, 0, nil},
 		},
 	},
 	{
-		&Order[string]{[]string{"a"}, 1},
+		&Order[string]{[]string{"a"}, 1, nil},
 		"b",
 		[]*Order[string]{
-			{[]string{"a", "b"}, 2},
-			{[]string{"a", "b"}, 1},
+			{[]string{"a", "b"}, 2, nil},
+			{[]string{"a", "b"}, 1, nil},
 		},
 	},
 	{
-		&Order[string]{[]string{"a", "b"}, 0},
+		&Order[string]{[]string{"a", "b"}, 0, nil},
 		"c",
 		[]*Order[string]{
-			{[]string{"c", "b", "a"}, 0},
-			{[]string{"a", "c", "b"}, 0},
-			{[]string{"a", "b", "c"}, 0},
+			{[]string{"c", "b", "a"}, 0, nil},
+			{[]string{"a", "c", "b"}, 0, nil},
+			{[]string{"a", "b", "c"}, 0, nil},
 		},
 	},
 	{
-		&Order[string]{[]string{"a", "b"}, 1},
+		&Order[string]{[]string{"a", "b"}, 1, nil},
 		"c",
 		[]*Order[string]{
-			{[]string{"a", "c", "b"}, 2},
-			{[]string{"a", "c", "b"}, 1},
-			{[]string{"a", "b", "c"}, 1},
+			{[]string{"a", "c", "b"}, 2, nil},
+			{[]string{"a", "c", "b"}, 1, nil},
+			{[]string{"a", "b", "c"}, 1, nil},
 		},
 	},
 	{
-		&Order[string]{[]string{"a", "b"}, 2},
+		&Order[string]{[]string{"a", "b"}, 2, nil},
 		"c",
 		[]*Order[string]{
-			{[]string{"a", "b", "c"}, 3},
-			{[]string{"a", "b", "c"}, 3},
-			{[]string{"a", "b", "c"}, 2},
+			{[]string{"a", "b", "c"}, 3, nil},
+			{[]string{"a", "b", "c"}, 3, nil},
+			{[]string{"a", "b", "c"}, 2, nil},
 		},
 	},
 }
@@ -77,14 +78,14 @@ func TestAddAt(t *testing.T) {
 }
 
 func TestNext(t *testing.T) {
-	o := &Order[string]{[]string{"a", "b", "c"}, 0}
+	o := &Order[string]{[]string{"a", "b", "c"}, 0, nil}
 	for _, tt := range []struct {
 		next string
 		o    *Order[string]
 	}{
-		{"a", &Order[string]{[]string{"a", "b", "c"}, 1}},
-		{"b", &Order[string]{[]string{"a", "b", "c"}, 2}},
-		{"c", &Order[string]{[]string{"a", "b", "c"}, 3}},
+		{"a", &Order[string]{[]string{"a", "b", "c"}, 1, nil}},
+		{"b", &Order[string]{[]string{"a", "b", "c"}, 2, nil}},
+		{"c", &Order[string]{[]string{"a", "b", "c"}, 3, nil}},
 	} {
 		old := clone(o)
 		got := o.Next()
@@ -112,45 +113,45 @@ var removeTests = []struct {
 	wants []*Order[string]
 }{
 	{
-		&Order[string]{[]string{"a"}, 0},
+		&Order[string]{[]string{"a"}, 0, nil},
 		[]*Order[string]{
-			{[]string{}, 0},
+			{[]string{}, 0, nil},
 		},
 	},
 	{
-		&Order[string]{[]string{"a"}, 1},
+		&Order[string]{[]string{"a"}, 1, nil},
 		[]*Order[string]{
-			{[]string{}, 0},
+			{[]string{}, 0, nil},
 		},
 	},
 	{
-		&Order[string]{[]string{"a", "b"}, 0},
+		&Order[string]{[]string{"a", "b"}, 0, nil},
 		[]*Order[string]{
-			{[]string{"b"}, 0},
-			{[]string{"a"}, 0},
+			{[]string{"b"}, 0, nil},
+			{[]string{"a"}, 0, nil},
 		},
 	},
 	{
-		&Order[string]{[]string{"a", "b"}, 1},
+		&Order[string]{[]string{"a", "b"}, 1, nil},
 		[]*Order[string]{
-			{[]string{"b"}, 0},
-			{[]string{"a"}, 1},
+			{[]string{"b"}, 0, nil},
+			{[]string{"a"}, 1, nil},
 		},
 	},
 	{
-		&Order[string]{[]string{"a", "b"}, 2},
+		&Order[string]{[]string{"a", "b"}, 2, nil},
 		[]*Order[string]{
-			{[]string{"b"}, 1},
-			{[]string{"a"}, 1},
+			{[]string{"b"}, 1, nil},
+			{[]string{"a"}, 1, nil},
 		},
 	},
 	{
-		&Order[string]{[]string{"a", "b", "c", "d"}, 2},
+		&Order[string]{[]string{"a", "b", "c", "d"}, 2, nil},
 		[]*Order[string]{
-			{[]string{"b", "d", "c"}, 1},
-			{[]string{"a", "d", "c"}, 1},
-			{[]string{"a", "b", "d"}, 2},
-			{[]string{"a", "b", "c"}, 2},
+			{[]string{"b", "d", "c"}, 1, nil},
+			{[]string{"a", "d", "c"}, 1, nil},
+			{[]string{"a", "b", "d"}, 2, nil},
+			{[]string{"a", "b", "c"}, 2, nil},
 		},
 	},
 }
@@ -171,7 +172,7 @@ func TestRemoveAt(t *testing.T) {
 
 // clone returns a fresh copy of o.
 func clone[T comparable](o *Order[T]) *Order[T] {
-	return &Order[T]{append([]T{}, o.a...), o.next}
+	return &Order[T]{append([]T{}, o.a...), o.next, o.rand}
 }
 
 // elemCount returns the counts of a's elements.
@@ -182,3 +183,42 @@ func elemCount[T comparable](a []T) map[T]int {
 	}
 	return m
 }
+
+func TestSetRand(t *testing.T) {
+	sequence := func(seed int64) []string {
+		o := new(Order[string])
+		o.SetRand(rand.New(rand.NewSource(seed)))
+		for _, s := range []string{"a", "b", "c", "d", "e"} {
+			o.Add(s)
+		}
+		o.Remove("c")
+		o.Add("f")
+		var seq []string
+		for i := 0; i < 15; i++ {
+			seq = append(seq, o.Next())
+		}
+		return seq
+	}
+	if a, b := sequence(1), sequence(1); !reflect.DeepEqual(a, b) {
+		t.Errorf("sequences with the same seed differ: %v, %v", a, b)
+	}
+}
+
+func TestClear(t *testing.T) {
+	o := new(Order[string])
+	for _, s := range []string{"a", "b", "c"} {
+		o.Add(s)
+	}
+	o.Next()
+	o.Clear()
+	if len(o.a) != 0 || o.next != 0 {
+		t.Fatalf("after Clear: got %+v, expected empty Order", o)
+	}
+	if got := o.Next(); got != "" {
+		t.Errorf("Next after Clear: got %q, expected zero value", got)
+	}
+	o.Add("d")
+	if got := o.Next(); got != "d" {
+		t.Errorf("Next after Clear and Add: got %q, expected d", got)
+	}
+}
@@ -20,6 +20,7 @@ func TestPQSwap(t *testing.T) {
 					{"ghi", 4, 5},
 				},
 				map[string]int{"abc": 0, "def": 1, "ghi": 2},
+				nil,
 			},
 			0, 1,
 			priorityQueue[string, int]{
@@ -29,6 +30,7 @@ func TestPQSwap(t *testing.T) {
 					{"ghi", 4, 5},
 				},
 				map[string]int{"abc": 1, "def": 0, "ghi": 2},
+				nil,
 			},
 		},
 		{
@@ -39,6 +41,7 @@ func TestPQSwap(t *testing.T) {
 					{"ghi", 4, 5},
 				},
 				map[string]int{"": 0, "def": 1, "ghi": 2},
+				nil,
 			},
 			0, 1,
 			priorityQueue[string, int]{
@@ -48,6 +51,7 @@ func TestPQSwap(t *testing.T) {
 					{"ghi", 4, 5},
 				},
 				map[string]int{"def": 0, "": 1, "ghi": 2},
+				nil,
 			},
 		},
 	} {
@@ -68,11 +72,12 @@ func TestPQPush(t *testing.T) {
 		want priorityQueue[string, int]
 	}{
 		{
-			makePriorityQueue[string, int](),
+			makePriorityQueue[string, int](nil),
 			&item[string, int]{"", 2, 0},
 			priorityQueue[string, int]{
 				[]*item[string, int]{{"", 2, 0}},
 				map[string]int{"": 0},
+				nil,
 			},
 		},
 		{
@@ -81,6 +86,7 @@ func TestPQPush(t *testing.T) {
 					{"", 2, 0},
 				},
 				map[string]int{"": 0},
+				nil,
 			},
 			&item[string, int]{"abc", 4, 0},
 			priorityQueue[string, int]{
@@ -89,6 +95,7 @@ func TestPQPush(t *testing.T) {
 					{"abc", 4, 0},
 				},
 				map[string]int{"": 0, "abc": 1},
+				nil,
 			},
 		},
 		{
@@ -98,6 +105,7 @@ func TestPQPush(t *testing.T) {
 					{"def", 3, 2},
 				},
 				map[string]int{"abc": 0, "def": 1},
+				nil,
 			},
 			&item[string, int]{"ghi", 5, 0},
 			priorityQueue[string, int]{
@@ -107,6 +115,7 @@ func TestPQPush(t *testing.T) {
 					{"ghi", 5, 0},
 				},
 				map[string]int{"abc": 0, "def": 1, "ghi": 2},
+				nil,
 			},
 		},
 	} {
@@ -132,11 +141,13 @@ func TestPQPop(t *testing.T) {
 					{"abc", 2, 0},
 				},
 				map[string]int{"abc": 0},
+				nil,
 			},
 			&item[string, int]{"abc", 2, 0},
 			priorityQueue[string, int]{
 				[]*item[string, int]{},
 				map[string]int{},
+				nil,
 			},
 		},
 		{
@@ -146,6 +157,7 @@ func TestPQPop(t *testing.T) {
 					{"def", 3, 2},
 				},
 				map[string]int{"abc": 0, "def": 1},
+				nil,
 			},
 			&item[string, int]{"def", 3, 2},
 			priorityQueue[string, int]{
@@ -153,6 +165,7 @@ func TestPQPop(t *testing.T) {
 					{"abc", 2, 0},
 				},
 				map[string]int{"abc": 0},
+				nil,
 			},
 		},
 		{
@@ -163,6 +176,7 @@ func TestPQPop(t *testing.T) {
 					{"ghi", 5, 0},
 				},
 				map[string]int{"abc": 0, "def": 1, "ghi": 2},
+				nil,
 			},
 			&item[string, int]{"ghi", 5, 0},
 			priorityQueue[string, int]{
@@ -171,6 +185,7 @@ func TestPQPop(t *testing.T) {
 					{"def", 3, 2},
 				},
 				map[string]int{"abc": 0, "def": 1},
+				nil,
 			},
 		},
 	} {
@@ -5,10 +5,19 @@ package rpq
 
 import "container/heap"
 
-// A Queue is a recurrent priority queue of key-value pairs.
+// A Queue is a recurrent priority queue of key-value pairs. A Queue is not
+// safe for concurrent use; see SyncQueue.
 type Queue[K comparable, V any] struct {
-	pq    priorityQueue[K, V]
-	quota func() int
+	pq     priorityQueue[K, V]
+	quota  func() int
+	counts Counts
+}
+
+// Counts tallies the items of a Queue that have reached their quota or been
+// superseded before doing so.
+type Counts struct {
+	Retired    uint64 // items removed upon reaching their quota
+	Superseded uint64 // items whose values were replaced by Upsert
 }
 
 // An item is a key-value pair with an associated return count.
@@ -20,9 +29,12 @@ type item[K comparable, V any] struct {
 
 // New initializes a new Queue. Quota describes the minimum number of times an
 // item will be returned by Pop or PopN before it is removed from the Queue.
-func New[K comparable, V any](quota func() int) *Queue[K, V] {
+// Among items that have been returned equally many times, those whose values
+// are less according to less have priority; if less is nil, ties are broken
+// arbitrarily.
+func New[K comparable, V any](quota func() int, less func(a, b V) bool) *Queue[K, V] {
 	return &Queue[K, V]{
-		pq:    makePriorityQueue[K, V](),
+		pq:    makePriorityQueue[K, V](less),
 		quota: quota,
 	}
 }
@@ -30,12 +42,20 @@ func New[K comparable, V any](quota func() int) *Queue[K, V] {
 // Upsert inserts a key-value pair into the Queue, or updates value if key is
 // already present.
 func (q *Queue[K, V]) Upsert(key K, value V) {
+	q.UpsertBoost(key, value, 0)
+}
+
+// UpsertBoost is like Upsert, but gives the item priority over items that
+// have been returned fewer than boost times, and returns it boost more times
+// than the quota requires before removing it.
+func (q *Queue[K, V]) UpsertBoost(key K, value V, boost int) {
 	if i, ok := q.pq.index[key]; ok {
+		q.counts.Superseded++
 		q.pq.items[i].value = value
-		q.pq.items[i].count = 0
+		q.pq.items[i].count = -boost
 		heap.Fix(&q.pq, i)
 	} else {
-		heap.Push(&q.pq, &item[K, V]{key: key, value: value})
+		heap.Push(&q.pq, &item[K, V]{key: key, value: value, count: -boost})
 	}
 }
 
@@ -43,13 +63,28 @@ func (q *Queue[K, V]) Upsert(key K, value V) {
 // the number of times it has been returned is greater than or equal to the
 // value returned by quota. Pop panics if the Queue is empty.
 func (q *Queue[K, V]) Pop() V {
-	it := heap.Pop(&q.pq).(*item[K, V])
+	// The item of highest priority is at the root. If it remains under
+	// quota, incrementing its count in place and sifting it down is cheaper
+	// than popping it and pushing it back.
+	it := q.pq.items[0]
 	if it.count++; it.count < q.quota() {
-		heap.Push(&q.pq, it)
+		heap.Fix(&q.pq, 0)
+	} else {
+		heap.Pop(&q.pq)
+		q.counts.Retired++
 	}
 	return it.value
 }
 
+// TryPop is like Pop, but if the Queue is empty, it returns the zero value
+// and false rather than panicking.
+func (q *Queue[K, V]) TryPop() (value V, ok bool) {
+	if q.pq.Len() == 0 {
+		return value, false
+	}
+	return q.Pop(), true
+}
+
 // PopN returns up to n distinct items of the highest priorities. If there are
 // at least n items in the queue, PopN returns n of them, or else all of them.
 // PopN removes any returned items from the Queue for which the number of times
@@ -57,6 +92,9 @@ func (q *Queue[K, V]) Pop() V {
 // quota.
 func (q *Queue[K, V]) PopN(n int) []V {
 	quota := q.quota()
+	if l := q.pq.Len(); l > 0 && n >= l {
+		return q.popAll(quota)
+	}
 	var values []V
 	var reinsert []*item[K, V]
 	for q.pq.Len() > 0 && len(values) < n {
@@ -64,6 +102,8 @@ func (q *Queue[K, V]) PopN(n int) []V {
 		values = append(values, it.value)
 		if it.count++; it.count < quota {
 			reinsert = append(reinsert, it)
+		} else {
+			q.counts.Retired++
 		}
 	}
 	for _, it := range reinsert {
@@ -72,6 +112,51 @@ func (q *Queue[K, V]) PopN(n int) []V {
 	return values
 }
 
+// popAll is PopN for n at least q.Len(). Rather than popping every item and
+// pushing back those under quota, it determines the order in which they would
+// be popped on a copy of the heap. Pushing items in that order moves none of
+// them, so the resulting heap is simply the items that remain, in order.
+func (q *Queue[K, V]) popAll(quota int) []V {
+	items := q.pq.popOrder()
+	values := make([]V, len(items))
+	kept := items[:0]
+	for i, it := range items {
+		values[i] = it.value
+		if it.count++; it.count < quota {
+			q.pq.index[it.key] = len(kept)
+			kept = append(kept, it)
+		} else {
+			delete(q.pq.index, it.key)
+			q.counts.Retired++
+		}
+	}
+	for i := len(kept); i < len(items); i++ {
+		items[i] = nil
+	}
+	q.pq.items = kept
+	return values
+}
+
+// Clear removes all items from the Queue. Clearing does not affect Counts.
+func (q *Queue[K, V]) Clear() {
+	q.pq = makePriorityQueue[K, V](q.pq.less)
+}
+
+// Range calls f for each item in the Queue, in no particular order, with the
+// number of times it has been returned, until f returns false. f must not
+// modify the Queue.
+func (q *Queue[K, V]) Range(f func(key K, value V, count int) bool) {
+	for _, it := range q.pq.items {
+		if !f(it.key, it.value, it.count) {
+			return
+		}
+	}
+}
+
+// Counts returns the numbers of items that have reached their quota and that
+// have been superseded since q was created.
+func (q *Queue[K, V]) Counts() Counts { return q.counts }
+
 // Len returns the number of items in the Queue.
 func (q *Queue[K, V]) Len() int { return q.pq.Len() }
 
@@ -79,16 +164,26 @@ func (q *Queue[K, V]) Len() int { return q.pq.Len() }
 type priorityQueue[K comparable, V any] struct {
 	items []*item[K, V]
 	index map[K]int
+	less  func(a, b V) bool // breaks ties between equal counts, if not nil
 }
 
-func makePriorityQueue[K comparable, V any]() priorityQueue[K, V] {
-	return priorityQueue[K, V]{index: make(map[K]int)}
+func makePriorityQueue[K comparable, V any](less func(a, b V) bool) priorityQueue[K, V] {
+	return priorityQueue[K, V]{index: make(map[K]int), less: less}
 }
 
 func (pq priorityQueue[K, V]) Len() int { return len(pq.items) }
 
 func (pq priorityQueue[K, V]) Less(i, j int) bool {
-	return pq.items[i].count < pq.items[j].count
+	return hasPriority(pq.items[i], pq.items[j], pq.less)
+}
+
+// hasPriority reports whether a has priority over b, breaking ties between
+// equal counts with less if it is not nil.
+func hasPriority[K comparable, V any](a, b *item[K, V], less func(a, b V) bool) bool {
+	if a.count != b.count || less == nil {
+		return a.count < b.count
+	}
+	return less(a.value, b.value)
 }
 
 func (pq priorityQueue[K, V]) Swap(i, j int) {
@@ -112,3 +207,41 @@ func (pq *priorityQueue[K, V]) Pop() any {
 	delete(pq.index, item.key)
 	return item
 }
+
+// popOrder returns pq's items in the order in which heap.Pop would remove
+// them, without modifying pq.
+func (pq priorityQueue[K, V]) popOrder() []*item[K, V] {
+	h := itemHeap[K, V]{append([]*item[K, V](nil), pq.items...), pq.less}
+	items := h.items
+	for h.Len() > 0 {
+		// Each popped item is left just past the end of the shrinking
+		// heap, so the slice ends up in reverse order
+		heap.Pop(&h)
+	}
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+	return items
+}
+
+// An itemHeap is a heap of items like a priorityQueue, but without an index,
+// and whose Pop leaves the popped item in place beyond the end of the heap.
+type itemHeap[K comparable, V any] struct {
+	items []*item[K, V]
+	less  func(a, b V) bool
+}
+
+func (h itemHeap[K, V]) Len() int { return len(h.items) }
+
+func (h itemHeap[K, V]) Less(i, j int) bool { return hasPriority(h.items[i], h.items[j], h.less) }
+
+func (h itemHeap[K, V]) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *itemHeap[K, V]) Push(any) { panic("rpq: push onto itemHeap") }
+
+func (h *itemHeap[K, V]) Pop() any {
+	last := len(h.items) - 1
+	it := h.items[last]
+	h.items = h.items[:last]
+	return it
+}
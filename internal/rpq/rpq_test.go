@@ -1,7 +1,10 @@
 package rpq
 
 import (
+	"container/heap"
 	"fmt"
+	"math"
+	"math/rand"
 	"reflect"
 	"sort"
 	"testing"
@@ -16,7 +19,7 @@ func TestPush(t *testing.T) {
 		want  *Queue[string, int]
 	}{
 		{
-			New[string, int](five),
+			New[string, int](five, nil),
 			"", 2,
 			&Queue[string, int]{
 				priorityQueue[string, int]{
@@ -24,12 +27,14 @@ func TestPush(t *testing.T) {
 						{"", 2, 0},
 					},
 					map[string]int{"": 0},
+					nil,
 				},
 				five,
+				Counts{},
 			},
 		},
 		{
-			New[string, int](five),
+			New[string, int](five, nil),
 			"abc", 2,
 			&Queue[string, int]{
 				priorityQueue[string, int]{
@@ -37,8 +42,10 @@ func TestPush(t *testing.T) {
 						{"abc", 2, 0},
 					},
 					map[string]int{"abc": 0},
+					nil,
 				},
 				five,
+				Counts{},
 			},
 		},
 		{
@@ -48,8 +55,10 @@ func TestPush(t *testing.T) {
 						{"", 2, 1},
 					},
 					map[string]int{"": 0},
+					nil,
 				},
 				five,
+				Counts{},
 			},
 			"abc", 2,
 			&Queue[string, int]{
@@ -59,8 +68,10 @@ func TestPush(t *testing.T) {
 						{"", 2, 1},
 					},
 					map[string]int{"abc": 0, "": 1},
+					nil,
 				},
 				five,
+				Counts{},
 			},
 		},
 		{
@@ -70,8 +81,10 @@ func TestPush(t *testing.T) {
 						{"abc", 2, 1},
 					},
 					map[string]int{"abc": 0},
+					nil,
 				},
 				five,
+				Counts{},
 			},
 			"", 2,
 			&Queue[string, int]{
@@ -81,8 +94,10 @@ func TestPush(t *testing.T) {
 						{"abc", 2, 1},
 					},
 					map[string]int{"": 0, "abc": 1},
+					nil,
 				},
 				five,
+				Counts{},
 			},
 		},
 		{
@@ -93,8 +108,10 @@ func TestPush(t *testing.T) {
 						{"def", 3, 2},
 					},
 					map[string]int{"": 0, "def": 1},
+					nil,
 				},
 				five,
+				Counts{},
 			},
 			"abc", 2,
 			&Queue[string, int]{
@@ -105,8 +122,10 @@ func TestPush(t *testing.T) {
 						{"def", 3, 2},
 					},
 					map[string]int{"abc": 0, "": 1, "def": 2},
+					nil,
 				},
 				five,
+				Counts{},
 			},
 		},
 		{
@@ -118,8 +137,10 @@ func TestPush(t *testing.T) {
 						{"def", 3, 3},
 					},
 					map[string]int{"": 0, "abc": 1, "def": 2},
+					nil,
 				},
 				five,
+				Counts{},
 			},
 			"abc", 5,
 			&Queue[string, int]{
@@ -130,8 +151,10 @@ func TestPush(t *testing.T) {
 						{"def", 3, 3},
 					},
 					map[string]int{"abc": 0, "": 1, "def": 2},
+					nil,
 				},
 				five,
+				Counts{},
 			},
 		},
 	} {
@@ -161,8 +184,10 @@ func TestPop(t *testing.T) {
 						{"ghi", 0, 4},
 					},
 					map[string]int{"abc": 0, "def": 1, "ghi": 2},
+					nil,
 				},
 				five,
+				Counts{},
 			},
 			6,
 			&Queue[string, int]{
@@ -173,8 +198,10 @@ func TestPop(t *testing.T) {
 						{"ghi", 0, 4},
 					},
 					map[string]int{"abc": 0, "def": 1, "ghi": 2},
+					nil,
 				},
 				five,
+				Counts{},
 			},
 		},
 		{
@@ -186,8 +213,10 @@ func TestPop(t *testing.T) {
 						{"ghi", 0, 4},
 					},
 					map[string]int{"abc": 0, "def": 1, "ghi": 2},
+					nil,
 				},
 				five,
+				Counts{},
 			},
 			6,
 			&Queue[string, int]{
@@ -198,8 +227,10 @@ func TestPop(t *testing.T) {
 						{"ghi", 0, 4},
 					},
 					map[string]int{"abc": 1, "def": 0, "ghi": 2},
+					nil,
 				},
 				five,
+				Counts{},
 			},
 		},
 		{
@@ -211,8 +242,10 @@ func TestPop(t *testing.T) {
 						{"ghi", 0, 5},
 					},
 					map[string]int{"abc": 0, "def": 1, "ghi": 2},
+					nil,
 				},
 				five,
+				Counts{},
 			},
 			6,
 			&Queue[string, int]{
@@ -222,8 +255,10 @@ func TestPop(t *testing.T) {
 						{"ghi", 0, 5},
 					},
 					map[string]int{"def": 0, "ghi": 1},
+					nil,
 				},
 				five,
+				Counts{},
 			},
 		},
 	} {
@@ -238,6 +273,20 @@ func TestPop(t *testing.T) {
 	}
 }
 
+func TestTryPop(t *testing.T) {
+	q := New[string, int](func() int { return 1 }, nil)
+	if v, ok := q.TryPop(); ok || v != 0 {
+		t.Errorf("TryPop on empty queue: got %v, %v; expected 0, false", v, ok)
+	}
+	q.Upsert("abc", 1)
+	if v, ok := q.TryPop(); !ok || v != 1 {
+		t.Errorf("TryPop: got %v, %v; expected 1, true", v, ok)
+	}
+	if v, ok := q.TryPop(); ok || v != 0 {
+		t.Errorf("TryPop after retirement: got %v, %v; expected 0, false", v, ok)
+	}
+}
+
 func TestPopN(t *testing.T) {
 	five := func() int { return 5 }
 	for _, tt := range []struct {
@@ -255,8 +304,10 @@ func TestPopN(t *testing.T) {
 						{"def", 3, 3},
 					},
 					map[string]int{"": 0, "abc": 1, "def": 2},
+					nil,
 				},
 				five,
+				Counts{},
 			},
 			4,
 			[]int{1, 2, 3},
@@ -268,8 +319,10 @@ func TestPopN(t *testing.T) {
 						{"def", 3, 4},
 					},
 					map[string]int{"": 0, "abc": 1, "def": 2},
+					nil,
 				},
 				five,
+				Counts{},
 			},
 		},
 		{
@@ -286,8 +339,10 @@ func TestPopN(t *testing.T) {
 					map[string]int{
 						"a": 0, "b": 1, "c": 2, "d": 3, "e": 4, "f": 5,
 					},
+					nil,
 				},
 				five,
+				Counts{},
 			},
 			4,
 			[]int{1, 2, 3, 4},
@@ -304,8 +359,10 @@ func TestPopN(t *testing.T) {
 					map[string]int{
 						"a": 0, "b": 1, "c": 2, "d": 3, "e": 4, "f": 5,
 					},
+					nil,
 				},
 				five,
+				Counts{},
 			},
 		},
 		{
@@ -317,8 +374,10 @@ func TestPopN(t *testing.T) {
 						{"c", 3, 4},
 					},
 					map[string]int{"a": 0, "b": 1, "c": 2},
+					nil,
 				},
 				five,
+				Counts{},
 			},
 			4,
 			[]int{1, 2, 3},
@@ -328,8 +387,10 @@ func TestPopN(t *testing.T) {
 						{"a", 1, 4},
 					},
 					map[string]int{"a": 0},
+					nil,
 				},
 				five,
+				Counts{},
 			},
 		},
 	} {
@@ -344,3 +405,228 @@ func TestPopN(t *testing.T) {
 		}
 	}
 }
+
+func TestCounts(t *testing.T) {
+	q := New[string, int](func() int { return 2 }, nil)
+	q.Upsert("abc", 1)
+	q.Upsert("def", 2)
+	q.Upsert("abc", 3)
+	q.PopN(2)
+	q.Pop()
+	q.Pop()
+	if got, want := q.Counts(), (Counts{Retired: 2, Superseded: 1}); got != want {
+		t.Errorf("Counts(): got %+v, expected %+v", got, want)
+	}
+}
+
+func TestRange(t *testing.T) {
+	q := New[string, int](func() int { return 5 }, nil)
+	q.Upsert("abc", 1)
+	q.Upsert("def", 2)
+	q.Upsert("ghi", 3)
+	q.Pop()
+	got := make(map[string]valuecount[int])
+	q.Range(func(key string, value, count int) bool {
+		got[key] = valuecount[int]{value, count}
+		return true
+	})
+	if !reflect.DeepEqual(got, q.pq.toMap()) {
+		t.Errorf("Range: got %v, expected %v", got, q.pq.toMap())
+	}
+	var n int
+	q.Range(func(string, int, int) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Errorf("Range stopped after %v calls, expected 1", n)
+	}
+}
+
+// popPush is the former implementation of Pop, which pops the item of highest
+// priority and pushes it back if it remains under quota.
+func (q *Queue[K, V]) popPush() V {
+	it := heap.Pop(&q.pq).(*item[K, V])
+	if it.count++; it.count < q.quota() {
+		heap.Push(&q.pq, it)
+	} else {
+		q.counts.Retired++
+	}
+	return it.value
+}
+
+func TestPopMatchesPopPush(t *testing.T) {
+	quota := func() int { return 7 }
+	q, ref := New[int, int](quota, nil), New[int, int](quota, nil)
+	for i := 0; i < 1000; i++ {
+		if i%3 == 0 {
+			q.Upsert(i%20, i)
+			ref.Upsert(i%20, i)
+			continue
+		}
+		if q.Len() != ref.Len() {
+			t.Fatalf("after %v operations: Len %v, expected %v", i, q.Len(), ref.Len())
+		}
+		if q.Len() == 0 {
+			continue
+		}
+		if got, want := q.Pop(), ref.popPush(); got != want {
+			t.Fatalf("after %v operations: Pop returned %v, expected %v", i, got, want)
+		}
+	}
+	if q.Counts() != ref.Counts() {
+		t.Errorf("Counts: got %+v, expected %+v", q.Counts(), ref.Counts())
+	}
+}
+
+// BenchmarkPop pops the same few items repeatedly, as the message queues do
+// between updates.
+func BenchmarkPop(b *testing.B) {
+	for _, bb := range []struct {
+		name string
+		pop  func(*Queue[int, int]) int
+	}{
+		{"Fix", (*Queue[int, int]).Pop},
+		{"PopPush", (*Queue[int, int]).popPush},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			q := New[int, int](func() int { return math.MaxInt }, nil)
+			for i := 0; i < 8; i++ {
+				q.Upsert(i, i)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				bb.pop(q)
+			}
+		})
+	}
+}
+
+// popNPopPush is the former implementation of PopN, which pops up to n items
+// and pushes back those that remain under quota.
+func (q *Queue[K, V]) popNPopPush(n int) []V {
+	quota := q.quota()
+	var values []V
+	var reinsert []*item[K, V]
+	for q.pq.Len() > 0 && len(values) < n {
+		it := heap.Pop(&q.pq).(*item[K, V])
+		values = append(values, it.value)
+		if it.count++; it.count < quota {
+			reinsert = append(reinsert, it)
+		} else {
+			q.counts.Retired++
+		}
+	}
+	for _, it := range reinsert {
+		heap.Push(&q.pq, it)
+	}
+	return values
+}
+
+func TestPopNMatchesPopPush(t *testing.T) {
+	for _, less := range []func(a, b int) bool{nil, func(a, b int) bool { return a%5 < b%5 }} {
+		quota := func() int { return 7 }
+		q, ref := New[int, int](quota, less), New[int, int](quota, less)
+		r := rand.New(rand.NewSource(1))
+		for i := 0; i < 2000; i++ {
+			if i%3 == 0 {
+				k, boost := r.Intn(20), r.Intn(3)
+				q.UpsertBoost(k, i, boost)
+				ref.UpsertBoost(k, i, boost)
+				continue
+			}
+			n := r.Intn(q.Len() + 3)
+			got, want := q.PopN(n), ref.popNPopPush(n)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("after %v operations: PopN(%v) returned %v, expected %v", i, n, got, want)
+			}
+			// The heaps must match exactly for later ties to be broken
+			// the same way
+			if !reflect.DeepEqual(q.pq.items, ref.pq.items) || !reflect.DeepEqual(q.pq.index, ref.pq.index) {
+				t.Fatalf("after %v operations: heap %v, expected %v", i, q.pq.toMap(), ref.pq.toMap())
+			}
+		}
+		if q.Counts() != ref.Counts() {
+			t.Errorf("Counts: got %+v, expected %+v", q.Counts(), ref.Counts())
+		}
+	}
+}
+
+// BenchmarkPopN pops every item of a queue in each call, as makePacket does
+// when few messages await dissemination.
+func BenchmarkPopN(b *testing.B) {
+	for _, bb := range []struct {
+		name string
+		popN func(*Queue[int, int], int) []int
+	}{
+		{"PopAll", (*Queue[int, int]).PopN},
+		{"PopPush", (*Queue[int, int]).popNPopPush},
+	} {
+		for _, size := range []int{6, 64} {
+			b.Run(fmt.Sprintf("%v/%v", bb.name, size), func(b *testing.B) {
+				q := New[int, int](func() int { return math.MaxInt }, nil)
+				for i := 0; i < size; i++ {
+					q.Upsert(i, i)
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					bb.popN(q, size)
+				}
+			})
+		}
+	}
+}
+
+func TestClear(t *testing.T) {
+	q := New[string, int](func() int { return 2 }, nil)
+	q.Upsert("a", 1)
+	q.Upsert("b", 2)
+	q.Pop()
+	q.Clear()
+	if q.Len() != 0 {
+		t.Fatalf("Len after Clear: got %v, expected 0", q.Len())
+	}
+	q.Upsert("a", 3)
+	if q.Len() != 1 {
+		t.Fatalf("Len after Clear and Upsert: got %v, expected 1", q.Len())
+	}
+	if got := q.PopN(2); !reflect.DeepEqual(got, []int{3}) {
+		t.Errorf("PopN after Clear and Upsert: got %v, expected [3]", got)
+	}
+}
+
+func TestTiebreak(t *testing.T) {
+	q := New[string, int](func() int { return 2 }, func(a, b int) bool { return a > b })
+	for i, k := range []string{"a", "b", "c", "d"} {
+		q.Upsert(k, i)
+	}
+	// Counts take precedence over values
+	var got []int
+	for i := 0; i < 8; i++ {
+		got = append(got, q.Pop())
+	}
+	if want := []int{3, 2, 1, 0, 3, 2, 1, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, expected %v", got, want)
+	}
+}
+
+func TestUpsertBoost(t *testing.T) {
+	q := New[string, string](func() int { return 2 }, nil)
+	q.Upsert("a", "a")
+	q.Pop()
+	q.Upsert("b", "b")
+	q.UpsertBoost("c", "c", 2)
+	var got []string
+	counts := make(map[string]int)
+	for q.Len() > 0 {
+		v := q.Pop()
+		got = append(got, v)
+		counts[v]++
+	}
+	if got[0] != "c" || got[1] != "c" {
+		t.Errorf("got %v, expected c to be returned first twice", got)
+	}
+	if want := map[string]int{"a": 1, "b": 2, "c": 4}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("got counts %v, expected %v", counts, want)
+	}
+}
@@ -0,0 +1,82 @@
+package rpq
+
+import "sync"
+
+// A SyncQueue is a Queue that is safe for concurrent use by multiple
+// goroutines. Each method call is atomic with respect to the others, but
+// sequences of calls are not: another goroutine may pop or upsert an item
+// between a call to Len and a subsequent call to TryPop, for instance. For this
+// reason, SyncQueue has TryPop, which reports whether the queue was empty, but
+// not Pop, which panics.
+//
+// A Queue used by a single goroutine, or whose use is already serialized by
+// some other lock, need not be wrapped in a SyncQueue.
+type SyncQueue[K comparable, V any] struct {
+	mu sync.Mutex
+	q  *Queue[K, V]
+}
+
+// NewSync initializes a new SyncQueue. Quota and less are as described for
+// New, and may be called while the SyncQueue's lock is held, so they must not
+// call the SyncQueue's methods.
+func NewSync[K comparable, V any](quota func() int, less func(a, b V) bool) *SyncQueue[K, V] {
+	return &SyncQueue[K, V]{q: New[K, V](quota, less)}
+}
+
+// Upsert inserts a key-value pair into the SyncQueue, or updates value if key
+// is already present.
+func (q *SyncQueue[K, V]) Upsert(key K, value V) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.q.Upsert(key, value)
+}
+
+// UpsertBoost is like Queue's UpsertBoost.
+func (q *SyncQueue[K, V]) UpsertBoost(key K, value V, boost int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.q.UpsertBoost(key, value, boost)
+}
+
+// TryPop is like Queue's TryPop.
+func (q *SyncQueue[K, V]) TryPop() (value V, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.q.TryPop()
+}
+
+// PopN is like Queue's PopN.
+func (q *SyncQueue[K, V]) PopN(n int) []V {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.q.PopN(n)
+}
+
+// Clear is like Queue's Clear.
+func (q *SyncQueue[K, V]) Clear() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.q.Clear()
+}
+
+// Range is like Queue's Range. f is called while the SyncQueue's lock is
+// held, so it must not call the SyncQueue's methods.
+func (q *SyncQueue[K, V]) Range(f func(key K, value V, count int) bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.q.Range(f)
+}
+
+// Counts is like Queue's Counts.
+func (q *SyncQueue[K, V]) Counts() Counts {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.q.Counts()
+}
+
+// Len returns the number of items in the SyncQueue.
+func (q *SyncQueue[K, V]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.q.Len()
+}
@@ -0,0 +1,73 @@
+package rpq
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSyncQueue(t *testing.T) {
+	const quota, keys, workers = 3, 50, 8
+	q := NewSync[int, int](func() int { return quota }, nil)
+	if _, ok := q.TryPop(); ok {
+		t.Error("TryPop on empty queue: got ok")
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for k := w; k < keys; k += workers {
+				q.Upsert(k, k)
+			}
+		}(w)
+	}
+	wg.Wait()
+	if q.Len() != keys {
+		t.Fatalf("Len: got %v, expected %v", q.Len(), keys)
+	}
+
+	var mu sync.Mutex
+	returned := make(map[int]int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				v, ok := q.TryPop()
+				if !ok {
+					return
+				}
+				mu.Lock()
+				returned[v]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	for k := 0; k < keys; k++ {
+		if returned[k] != quota {
+			t.Errorf("key %v returned %v times, expected %v", k, returned[k], quota)
+		}
+	}
+	if c := q.Counts(); c.Retired != keys {
+		t.Errorf("Counts: got %+v, expected %v retired", c, keys)
+	}
+
+	// A boosted item is returned first, and boost more times than the quota
+	q.Upsert(1, 1)
+	q.UpsertBoost(2, 2, 2)
+	returned = make(map[int]int)
+	for first := true; ; first = false {
+		v, ok := q.TryPop()
+		if !ok {
+			break
+		}
+		if first && v != 2 {
+			t.Errorf("TryPop after UpsertBoost: got %v first, expected 2", v)
+		}
+		returned[v]++
+	}
+	if returned[1] != quota || returned[2] != quota+2 {
+		t.Errorf("after UpsertBoost: returned %v, expected 1 %v times and 2 %v times", returned, quota, quota+2)
+	}
+}
@@ -0,0 +1,179 @@
+package swim
+
+import (
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// A Member describes a member of the network.
+type Member struct {
+	ID          string
+	Addr        netip.AddrPort
+	Incarnation int
+}
+
+// Members returns the members of the network known to n, including n itself
+// unless it is an observer, sorted by ID.
+func (n *Node) Members() []Member {
+	ms, _ := n.members()
+	sort.Slice(ms, func(i, j int) bool { return ms[i].ID < ms[j].ID })
+	return ms
+}
+
+// Peers is like Members, but excludes n itself.
+func (n *Node) Peers() []Member {
+	n.mu.Lock()
+	ms := make([]Member, 0, len(n.fsm.members))
+	n.rangeMembers(func(m Member) bool {
+		if m.ID != string(n.fsm.id) {
+			ms = append(ms, m)
+		}
+		return true
+	})
+	n.mu.Unlock()
+	sort.Slice(ms, func(i, j int) bool { return ms[i].ID < ms[j].ID })
+	return ms
+}
+
+// MembersWithGeneration is like Members, but also returns the Generation of
+// the membership it describes, both captured at the same instant. A consumer
+// that tracks the membership through join and failure handlers can register
+// the handlers, take a snapshot with MembersWithGeneration, and apply the
+// subsequent handler calls to the snapshot without missing a change. Handler
+// calls run asynchronously, so calls for changes already reflected in the
+// snapshot may follow it; the consumer should ignore joins of members it
+// already has and failures of peers it does not. Comparing Generation with
+// the returned value reports whether any change has occurred since.
+func (n *Node) MembersWithGeneration() ([]Member, uint64) {
+	ms, gen := n.members()
+	sort.Slice(ms, func(i, j int) bool { return ms[i].ID < ms[j].ID })
+	return ms, gen
+}
+
+// SortedMembers returns the IDs of the members of the network known to n,
+// including n itself unless it is an observer, in ascending order. Because the
+// order depends only on the membership, nodes that agree on the membership
+// agree on the order, so it can be used to shard work consistently among the
+// members. Any change to the membership changes the positions of other
+// members, so callers should reshard whenever the membership's Generation
+// changes.
+func (n *Node) SortedMembers() []string {
+	n.mu.Lock()
+	ids := make([]string, 0, len(n.fsm.members)+1)
+	n.rangeMembers(func(m Member) bool {
+		ids = append(ids, m.ID)
+		return true
+	})
+	n.mu.Unlock()
+	sort.Strings(ids)
+	return ids
+}
+
+// MembersByAddr is like Members, but sorts the members by address, and then
+// by ID among members with the same address.
+func (n *Node) MembersByAddr() []Member {
+	ms, _ := n.members()
+	sort.Slice(ms, func(i, j int) bool {
+		a, b := ms[i].Addr, ms[j].Addr
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c < 0
+		}
+		if a.Port() != b.Port() {
+			return a.Port() < b.Port()
+		}
+		return ms[i].ID < ms[j].ID
+	})
+	return ms
+}
+
+// RangeMembers calls f for each member of the network known to n, including n
+// itself unless it is an observer, in no particular order, until f returns
+// false. Unlike Members, it does not allocate.
+//
+// RangeMembers holds n's lock while calling f, so f must not call n's methods,
+// and n cannot process packets until RangeMembers returns.
+func (n *Node) RangeMembers(f func(Member) bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.rangeMembers(f)
+}
+
+// members returns the members of the network known to n in no particular
+// order, and the generation of the membership.
+func (n *Node) members() ([]Member, uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	ms := make([]Member, 0, len(n.fsm.members)+1)
+	n.rangeMembers(func(m Member) bool {
+		ms = append(ms, m)
+		return true
+	})
+	return ms, n.fsm.generation
+}
+
+// rangeMembers calls f for each member of the network known to n until f
+// returns false. n's own entry reports the address n announces, if it has
+// called UpdateAddr, or else its local address. The caller must hold n.mu.
+func (n *Node) rangeMembers(f func(Member) bool) {
+	if !n.fsm.observer {
+		self := Member{
+			ID:          string(n.fsm.id),
+			Addr:        n.fsm.addr,
+			Incarnation: n.fsm.incarnation,
+		}
+		if !self.Addr.IsValid() {
+			self.Addr = n.LocalAddr()
+		}
+		if !f(self) {
+			return
+		}
+	}
+	for id, p := range n.fsm.members {
+		m := Member{
+			ID:          string(id),
+			Addr:        p.addr,
+			Incarnation: p.incarnation,
+		}
+		if !f(m) {
+			return
+		}
+	}
+}
+
+// SuspectInfo reports whether n currently suspects the peer with the given ID
+// of having failed, and if so, for how many protocol periods it has been under
+// suspicion. ok is false if the peer is not under suspicion, including if it
+// is not a member.
+func (n *Node) SuspectInfo(nodeID string) (periods int, ok bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	periods, ok = n.fsm.suspects[id(nodeID)]
+	return periods, ok
+}
+
+// Generation returns a counter that n increments whenever a peer joins or
+// leaves the network. Callers can compare it with a previously observed value
+// to detect cheaply whether the membership has changed.
+func (n *Node) Generation() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.fsm.generation
+}
+
+// LastSeen returns the time at which n last heard that the peer with the given
+// ID was alive, either from the peer itself in response to a probe, or from
+// membership information disseminated by any member. ok is false if the peer
+// is not a member, or if n has yet to hear that it is alive, as when n learned
+// of it only from news of its suspicion. The time carries a monotonic clock
+// reading, so durations computed from it with time.Since are unaffected by
+// changes to the wall clock.
+func (n *Node) LastSeen(nodeID string) (t time.Time, ok bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	p, ok := n.fsm.members[id(nodeID)]
+	if !ok || p.lastSeen.IsZero() {
+		return time.Time{}, false
+	}
+	return p.lastSeen, true
+}
@@ -0,0 +1,167 @@
+package swim
+
+import (
+	"net/netip"
+	"sort"
+	"testing"
+	"time"
+
+	"kr.dev/diff"
+)
+
+func TestMembers(t *testing.T) {
+	n, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	addrs := []netip.AddrPort{
+		netip.MustParseAddrPort("[::1]:3000"),
+		netip.MustParseAddrPort("127.0.0.1:2000"),
+		netip.MustParseAddrPort("127.0.0.1:1000"),
+	}
+	n.mu.Lock()
+	n.fsm.id = "MMM"
+	n.fsm.incarnation = 1
+	n.mu.Unlock()
+	n.receive(packet{
+		Type:     ping,
+		remoteID: "BBB",
+		Msgs: []*message{
+			{Type: alive, NodeID: "BBB", Addr: addrs[0], Incarnation: 2},
+			{Type: alive, NodeID: "AAA", Addr: addrs[1], Incarnation: 3},
+			{Type: alive, NodeID: "CCC", Addr: addrs[2], Incarnation: 4},
+		},
+	})
+	self := Member{ID: "MMM", Addr: n.LocalAddr(), Incarnation: 1}
+	a := Member{ID: "AAA", Addr: addrs[1], Incarnation: 3}
+	b := Member{ID: "BBB", Addr: addrs[0], Incarnation: 2}
+	c := Member{ID: "CCC", Addr: addrs[2], Incarnation: 4}
+
+	// n listens on the unspecified IPv6 address
+	diff.Test(t, t.Errorf, n.Members(), []Member{a, b, c, self})
+	diff.Test(t, t.Errorf, n.Peers(), []Member{a, b, c})
+	diff.Test(t, t.Errorf, n.MembersByAddr(), []Member{c, a, self, b})
+	diff.Test(t, t.Errorf, n.SortedMembers(), []string{"AAA", "BBB", "CCC", "MMM"})
+
+	var ms []Member
+	n.RangeMembers(func(m Member) bool {
+		ms = append(ms, m)
+		return true
+	})
+	sort.Slice(ms, func(i, j int) bool { return ms[i].ID < ms[j].ID })
+	diff.Test(t, t.Errorf, ms, []Member{a, b, c, self})
+
+	calls := 0
+	n.RangeMembers(func(Member) bool {
+		calls++
+		return calls < 2
+	})
+	if calls != 2 {
+		t.Errorf("RangeMembers did not stop: f called %v times, expected 2", calls)
+	}
+
+	// n reports the address it announces
+	public := netip.MustParseAddrPort("192.0.2.1:7946")
+	if err := n.UpdateAddr(public); err != nil {
+		t.Fatal(err)
+	}
+	self = Member{ID: "MMM", Addr: public, Incarnation: 2}
+	diff.Test(t, t.Errorf, n.Members(), []Member{a, b, c, self})
+}
+
+func TestSuspectInfo(t *testing.T) {
+	n, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	n.receive(packet{
+		Type:     ping,
+		remoteID: "BBB",
+		Msgs: []*message{
+			{Type: alive, NodeID: "AAA"},
+			{Type: suspected, NodeID: "BBB"},
+		},
+	})
+	n.mu.Lock()
+	n.fsm.suspects["BBB"] = 2
+	n.mu.Unlock()
+	for _, tt := range []struct {
+		id      string
+		periods int
+		ok      bool
+	}{
+		{"AAA", 0, false},
+		{"BBB", 2, true},
+		{"CCC", 0, false},
+	} {
+		periods, ok := n.SuspectInfo(tt.id)
+		if periods != tt.periods || ok != tt.ok {
+			t.Errorf("SuspectInfo(%v): got %v, %v; expected %v, %v", tt.id, periods, ok, tt.periods, tt.ok)
+		}
+	}
+}
+
+func TestGeneration(t *testing.T) {
+	n, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	diff.Test(t, t.Errorf, n.Generation(), uint64(0))
+	n.receive(packet{
+		Type:     ping,
+		remoteID: "AAA",
+		Msgs: []*message{
+			{Type: alive, NodeID: "AAA"},
+			{Type: alive, NodeID: "BBB"},
+		},
+	})
+	diff.Test(t, t.Errorf, n.Generation(), uint64(2))
+	n.receive(packet{
+		Type:     ping,
+		remoteID: "AAA",
+		Msgs: []*message{
+			{Type: alive, NodeID: "AAA", Incarnation: 1},
+			{Type: suspected, NodeID: "BBB"},
+		},
+	})
+	diff.Test(t, t.Errorf, n.Generation(), uint64(2))
+	n.receive(packet{
+		Type:     ping,
+		remoteID: "AAA",
+		Msgs:     []*message{{Type: failed, NodeID: "BBB"}},
+	})
+	diff.Test(t, t.Errorf, n.Generation(), uint64(3))
+
+	ms, gen := n.MembersWithGeneration()
+	diff.Test(t, t.Errorf, gen, uint64(3))
+	diff.Test(t, t.Errorf, len(ms), 2)
+}
+
+func TestNodeLastSeen(t *testing.T) {
+	n, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	n.receive(packet{
+		Type:     ping,
+		remoteID: "BBB",
+		Msgs: []*message{
+			{Type: alive, NodeID: "BBB"},
+			{Type: suspected, NodeID: "AAA"},
+		},
+	})
+	if tm, ok := n.LastSeen("BBB"); !ok || time.Since(tm) > time.Second {
+		t.Errorf("LastSeen(BBB): got %v, %v; expected about now", tm, ok)
+	}
+	// n has heard only that AAA is suspected
+	if tm, ok := n.LastSeen("AAA"); ok {
+		t.Errorf("LastSeen(AAA): got %v, %v; expected not ok", tm, ok)
+	}
+	if tm, ok := n.LastSeen("CCC"); ok {
+		t.Errorf("LastSeen(CCC): got %v, %v; expected not ok", tm, ok)
+	}
+}
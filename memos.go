@@ -0,0 +1,69 @@
+package swim
+
+import "sort"
+
+// A MemoPriority determines which of the memos a Node is disseminating it
+// sends next. Under either priority, a Node sends at most one memo per packet
+// and sends each memo the same number of times. A memo is queued once, when
+// the Node posts it or first receives it; a Node discards memos whose IDs it
+// has already seen, so a memo that reaches it again, even from a different
+// sender, neither resets its count nor counts as newer.
+type MemoPriority byte
+
+const (
+	// FewestSentFirst sends the memo that has been sent the fewest times,
+	// breaking ties by ID, so that memos share packets evenly.
+	FewestSentFirst MemoPriority = iota
+
+	// NewestFirst sends the memo that has been sent the fewest times,
+	// breaking ties in favor of the memo queued most recently. A new memo
+	// is thus sent ahead of all others, which suits memos that carry state
+	// in which the latest value wins.
+	NewestFirst
+)
+
+// A MemoStatus describes a memo that a Node is disseminating.
+type MemoStatus struct {
+	ID        string // the memo's ID, unique within the network
+	NodeID    string // the ID of the node that posted the memo
+	Len       int    // length of the memo's body
+	Sends     int    // number of times the Node has sent the memo
+	Remaining int    // number of times the Node will send the memo again
+}
+
+// PendingMemos returns the memos that n is disseminating, including memos
+// posted by other nodes, in the order in which they will next be sent. A memo
+// is disseminated until it has been sent a certain number of times, after
+// which it no longer appears.
+func (n *Node) PendingMemos() []MemoStatus {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	quota := n.fsm.memoQuota()
+	var ms []MemoStatus
+	queued := make(map[string]uint64)
+	n.fsm.memoQueue.Range(func(id id, m *message, count int) bool {
+		remaining := quota - count
+		if remaining < 0 {
+			remaining = 0
+		}
+		ms = append(ms, MemoStatus{
+			ID:        string(id),
+			NodeID:    string(m.NodeID),
+			Len:       len(m.Body),
+			Sends:     count,
+			Remaining: remaining,
+		})
+		queued[string(id)] = m.queued
+		return true
+	})
+	sort.Slice(ms, func(i, j int) bool {
+		if ms[i].Sends != ms[j].Sends {
+			return ms[i].Sends < ms[j].Sends
+		}
+		if n.fsm.newestFirst {
+			return queued[ms[i].ID] > queued[ms[j].ID]
+		}
+		return ms[i].ID < ms[j].ID
+	})
+	return ms
+}
@@ -0,0 +1,178 @@
+package swim
+
+import (
+	"errors"
+	"net/netip"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"kr.dev/diff"
+)
+
+func TestPendingMemos(t *testing.T) {
+	n, err := Start("", WithMemoBudget(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	n.mu.Lock()
+	n.fsm.id = "MMM"
+	n.fsm.memoQueue.Upsert("1", &message{NodeID: "AAA", MemoID: "1", Body: []byte("one")})
+	n.fsm.memoQueue.Upsert("2", &message{NodeID: "MMM", MemoID: "2", Body: []byte("three")})
+	n.fsm.memoQueue.Pop()
+	n.mu.Unlock()
+
+	diff.Test(t, t.Errorf, n.PendingMemos(), []MemoStatus{
+		{ID: "2", NodeID: "MMM", Len: 5, Sends: 0, Remaining: 3},
+		{ID: "1", NodeID: "AAA", Len: 3, Sends: 1, Remaining: 2},
+	})
+	// Memos are listed in the order in which they are sent
+	n.mu.Lock()
+	for _, memoID := range []id{"5", "3", "4", "6"} {
+		n.fsm.memoQueue.Upsert(memoID, &message{NodeID: "AAA", MemoID: memoID})
+	}
+	n.mu.Unlock()
+	var listed, sent []string
+	for _, m := range n.PendingMemos() {
+		listed = append(listed, m.ID)
+	}
+	n.mu.Lock()
+	for range listed {
+		m, _ := n.fsm.memoQueue.TryPop()
+		sent = append(sent, string(m.MemoID))
+	}
+	n.mu.Unlock()
+	diff.Test(t, t.Errorf, listed, sent)
+}
+
+func TestPostMemoWithID(t *testing.T) {
+	n, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	for _, memoID := range []string{"", "app 1", "app\n1", "é", strings.Repeat("a", maxMemoIDLen+1)} {
+		if err := n.PostMemoWithID(memoID, []byte("x")); !errors.Is(err, ErrInvalidMemoID) {
+			t.Errorf("PostMemoWithID(%q): got %v, expected ErrInvalidMemoID", memoID, err)
+		}
+	}
+
+	// Posting a memo again does nothing
+	for i := 0; i < 2; i++ {
+		if err := n.PostMemoWithID("app:1", []byte("one")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Nor does posting a memo already received from a peer
+	n.receive(packet{
+		Type:     ping,
+		remoteID: "AAA",
+		Msgs: []*message{
+			{Type: alive, NodeID: "AAA"},
+			{Type: alive, NodeID: "AAA", MemoID: "app:2", Body: []byte("two")},
+		},
+	})
+	if err := n.PostMemoWithID("app:2", []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []MemoStatus
+	for _, ms := range n.PendingMemos() {
+		got = append(got, MemoStatus{ID: ms.ID, NodeID: ms.NodeID})
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].ID < got[j].ID })
+	diff.Test(t, t.Errorf, got, []MemoStatus{
+		{ID: "app:1", NodeID: n.ID()},
+		{ID: "app:2", NodeID: "AAA"},
+	})
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.fsm.memoQueue.Range(func(_ id, m *message, _ int) bool {
+		if m.Seq != 0 {
+			t.Errorf("memo %v: got sequence number %v, expected 0", m.MemoID, m.Seq)
+		}
+		return true
+	})
+}
+
+func TestMemoPriority(t *testing.T) {
+	if _, err := Start("", WithMemoPriority(NewestFirst+1)); err == nil {
+		t.Error("invalid memo priority: got nil error")
+	}
+
+	n, err := Start("", WithMemoPriority(NewestFirst), WithMemoBudget(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	n.mu.Lock()
+	for _, memoID := range []id{"a", "b", "c"} {
+		n.fsm.addMemoWithID(memoID, []byte(memoID))
+	}
+	n.mu.Unlock()
+	var pending []string
+	for _, ms := range n.PendingMemos() {
+		pending = append(pending, ms.ID)
+	}
+	diff.Test(t, t.Errorf, pending, []string{"c", "b", "a"})
+
+	// The newest memo preempts older ones, but not at the expense of
+	// memos that have been sent fewer times
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var got []string
+	for i := 0; i < 2; i++ {
+		got = append(got, string(n.fsm.memoQueue.Pop().MemoID))
+	}
+	n.fsm.addMemoWithID("d", []byte("d"))
+	for i := 0; i < 3; i++ {
+		got = append(got, string(n.fsm.memoQueue.Pop().MemoID))
+	}
+	diff.Test(t, t.Errorf, got, []string{"c", "b", "d", "a", "d"})
+}
+
+func TestSelfMemoDelivery(t *testing.T) {
+	for _, deliver := range []bool{false, true} {
+		var opts []Option
+		if deliver {
+			opts = append(opts, WithSelfMemoDelivery())
+		}
+		n, err := Start("", append(opts, WithSyncHandlers())...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		memos := make(chan string, 3)
+		n.OnMemo(func(nodeID string, _ netip.AddrPort, memo []byte) {
+			if nodeID != n.ID() {
+				t.Errorf("memo %q reported from %v, expected %v", memo, nodeID, n.ID())
+			}
+			memos <- string(memo)
+		})
+		if err := n.PostMemo([]byte("one")); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := n.PostMemoWithID("app:1", []byte("two")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var want []string
+		if deliver {
+			want = []string{"one", "two"}
+		}
+		var got []string
+	receive:
+		for {
+			select {
+			case memo := <-memos:
+				got = append(got, memo)
+			case <-time.After(50 * time.Millisecond):
+				break receive
+			}
+		}
+		n.Shutdown()
+		diff.Test(t, t.Errorf, got, want)
+	}
+}
@@ -0,0 +1,174 @@
+package swim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+)
+
+// muxQueueLen is the number of received packets a Mux queues for each Node
+// before it begins to discard them.
+const muxQueueLen = 64
+
+// A Mux shares a single UDP socket among Nodes belonging to different
+// clusters, routing each received packet to the Node whose cluster name
+// matches the packet's label. Packets for clusters with no Node on the Mux
+// are discarded.
+type Mux struct {
+	conn *net.UDPConn
+
+	mu     sync.Mutex // protects the following fields
+	conns  map[string]*muxConn
+	closed bool
+}
+
+// Listen creates a new Mux listening on the local UDP address, which is
+// interpreted as by Start.
+func Listen(address string) (*Mux, error) {
+	addr, err := resolveAddr(address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	m := &Mux{
+		conn:  conn,
+		conns: make(map[string]*muxConn),
+	}
+	go m.run()
+	return m, nil
+}
+
+// Start creates a new Node in the named cluster that sends and receives
+// packets through m. It is equivalent to calling the package-level Start with
+// WithClusterName(cluster), except that the Node shares m's socket. At most
+// one Node per cluster can be attached to m at a time; shutting a Node down
+// detaches it.
+func (m *Mux) Start(cluster string, opts ...Option) (*Node, error) {
+	cfg, err := newConfig(append(opts[:len(opts):len(opts)], WithClusterName(cluster)))
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, net.ErrClosed
+	}
+	if _, ok := m.conns[cluster]; ok {
+		return nil, fmt.Errorf("cluster %q already attached", cluster)
+	}
+	c := &muxConn{
+		m:       m,
+		cluster: cluster,
+		in:      make(chan datagram, muxQueueLen),
+		done:    make(chan struct{}),
+	}
+	m.conns[cluster] = c
+	return start(c, cfg), nil
+}
+
+// Close closes m's socket, shutting down all of the Nodes attached to it.
+func (m *Mux) Close() error {
+	return m.conn.Close()
+}
+
+// LocalAddr returns the local network address.
+func (m *Mux) LocalAddr() netip.AddrPort {
+	return m.conn.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+// run routes received packets to the attached Nodes until m's socket is
+// closed.
+func (m *Mux) run() {
+	defer func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.closed = true
+		for _, c := range m.conns {
+			c.detach()
+		}
+	}()
+	readLoop(m.conn, maxReceiveBufferSize, nil, func(b []byte, addr netip.AddrPort) bool {
+		if b == nil {
+			return true
+		}
+		u, err := decompress(b)
+		if err != nil {
+			return true
+		}
+		var label struct{ Cluster string }
+		if err := json.Unmarshal(u, &label); err != nil {
+			return true
+		}
+		m.mu.Lock()
+		c, ok := m.conns[label.Cluster]
+		m.mu.Unlock()
+		if !ok {
+			return true
+		}
+		select {
+		case c.in <- datagram{append([]byte(nil), b...), addr}:
+		default:
+			// Queue full; discard the packet as an overflowing socket
+			// buffer would
+		}
+		return true
+	})
+}
+
+// A datagram is a packet received by a Mux.
+type datagram struct {
+	b    []byte
+	addr netip.AddrPort
+}
+
+// A muxConn is the Transport of a Node attached to a Mux.
+type muxConn struct {
+	m       *Mux
+	cluster string
+	in      chan datagram
+	done    chan struct{} // closed when c is detached from m
+}
+
+func (c *muxConn) ReadFromUDPAddrPort(b []byte) (int, netip.AddrPort, error) {
+	select {
+	case d := <-c.in:
+		return copy(b, d.b), d.addr, nil
+	case <-c.done:
+		return 0, netip.AddrPort{}, net.ErrClosed
+	}
+}
+
+func (c *muxConn) WriteToUDPAddrPort(b []byte, addr netip.AddrPort) (int, error) {
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	default:
+	}
+	return c.m.conn.WriteToUDPAddrPort(b, addr)
+}
+
+// Close detaches c from its Mux.
+func (c *muxConn) Close() error {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	if c.m.conns[c.cluster] != c {
+		return net.ErrClosed
+	}
+	c.detach()
+	return nil
+}
+
+// detach removes c from its Mux. The caller must hold c.m.mu.
+func (c *muxConn) detach() {
+	delete(c.m.conns, c.cluster)
+	close(c.done)
+}
+
+func (c *muxConn) LocalAddr() net.Addr {
+	return c.m.conn.LocalAddr()
+}
@@ -0,0 +1,96 @@
+package swim
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"kr.dev/diff"
+)
+
+func TestMux(t *testing.T) {
+	var muxes [2]*Mux
+	for i := range muxes {
+		m, err := Listen("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer m.Close()
+		muxes[i] = m
+	}
+	clusters := []string{"a", "b"}
+	nodes := make(map[string][2]*Node)
+	joins := make(map[string]chan string)
+	for _, cluster := range clusters {
+		var ns [2]*Node
+		for i, m := range muxes {
+			n, err := m.Start(cluster)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ns[i] = n
+		}
+		nodes[cluster] = ns
+		ch := make(chan string, 2)
+		ns[0].OnJoin(func(id string, _ netip.AddrPort) { ch <- id })
+		joins[cluster] = ch
+	}
+	if _, err := muxes[0].Start("a"); err == nil {
+		t.Error("Start with attached cluster: got nil error")
+	}
+
+	addr0 := loopback(muxes[0].LocalAddr())
+	for _, cluster := range clusters {
+		nodes[cluster][1].Join(addr0)
+		diff.Test(t, t.Errorf, <-joins[cluster], nodes[cluster][1].ID())
+	}
+	for _, cluster := range clusters {
+		select {
+		case id := <-joins[cluster]:
+			t.Errorf("cluster %v: unexpected join by %v", cluster, id)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	n := nodes["a"][0]
+	if err := n.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Shutdown(); !errors.Is(err, ErrNodeClosed) {
+		t.Errorf("second Shutdown: got %v, expected %v", err, ErrNodeClosed)
+	}
+	if _, err := muxes[0].Start("a"); err != nil {
+		t.Errorf("Start after Shutdown: %v", err)
+	}
+}
+
+func TestClusterName(t *testing.T) {
+	n0, err := Start("", WithClusterName("swim"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n0.Shutdown()
+	joined := make(chan string, 1)
+	n0.OnJoin(func(id string, _ netip.AddrPort) { joined <- id })
+
+	n1, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n1.Shutdown()
+	n1.Join(n0.localAddrPort())
+	select {
+	case id := <-joined:
+		t.Errorf("unexpected join by %v", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if s := n0.Stats(); s.PacketsDropped == 0 {
+		t.Error("PacketsDropped: got 0, expected nonzero")
+	}
+}
+
+// loopback returns the loopback address with addr's port.
+func loopback(addr netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(netip.IPv6Loopback(), addr.Port())
+}
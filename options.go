@@ -0,0 +1,613 @@
+package swim
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// An Option configures a Node.
+type Option func(*config)
+
+const (
+	// minReceiveBufferSize is the minimum MTU of an IPv6 link, which
+	// comfortably exceeds the size of the packets a Node sends.
+	minReceiveBufferSize = 1280
+
+	// maxReceiveBufferSize is the maximum size of a UDP datagram.
+	maxReceiveBufferSize = 1<<16 - 1
+
+	// minPacketSize is the minimum size of a datagram that every IPv4 host
+	// must be able to reassemble.
+	minPacketSize = 576
+)
+
+// config holds the settings that Options configure.
+type config struct {
+	suspicionJitter    float64
+	handlerConcurrency int // 0 for a goroutine per handler call
+	hysteresis         int
+	receiveBufferSize  int
+	readBuffer         int // size of the socket's receive buffer, or 0 for the OS default
+	writeBuffer        int // size of the socket's send buffer, or 0 for the OS default
+	maxPacketSize      int
+	clusterName        string
+	suspicion          bool
+	suspectMemos       bool
+	selfMemos          bool
+	gossipFanout       int
+	probesPerPeriod    int
+	retransmitMult     int
+	smallSize          int
+	smallMisses        int
+	compression        bool
+	idBytes            int
+	maxIdle            int
+	memoBudget         int
+	observer           bool
+	indirectTimeout    time.Duration
+	advertise          func(peer netip.AddrPort) netip.AddrPort
+	detector           FailureDetector // nil for the default
+	tracer             func(dir Direction, addr netip.AddrPort, p TracePacket)
+	maxMembers         int
+	joinFilter         func(id string, addr netip.AddrPort) bool
+	probeSeed          *int64 // nil to seed from the Node's ID
+	quarantine         time.Duration
+	removedCapacity    int     // removed ids to remember in a Bloom filter, or 0 to remember all exactly
+	removedFPRate      float64 // false positive rate of the removed ids' Bloom filter
+	memoPriority       MemoPriority
+	knownOnly          bool             // whether to ignore packets from unknown senders
+	trustedAddrs       []netip.AddrPort // addresses to accept packets from if knownOnly
+	rejoinSeeds        []netip.AddrPort // addresses to rejoin through after losing all members
+	rejoinGrace        int              // periods to wait before rejoining
+	conn               net.PacketConn   // socket to use instead of listening, or nil
+}
+
+// defaultConfig returns the configuration of a Node started without Options.
+func defaultConfig() config {
+	return config{
+		suspicionJitter: 0.1,
+		hysteresis:      1,
+		suspicion:       true,
+		suspectMemos:    true,
+		probesPerPeriod: 1,
+		retransmitMult:  2,
+		idBytes:         defaultIDBytes,
+		quarantine:      defaultQuarantine,
+
+		receiveBufferSize: maxReceiveBufferSize,
+		maxPacketSize:     1400,
+	}
+}
+
+// newConfig returns the default configuration as modified by opts, or an
+// error if the result is invalid.
+func newConfig(opts []Option) (config, error) {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if err := cfg.validate(); err != nil {
+		return config{}, err
+	}
+	return cfg, nil
+}
+
+// validate reports whether c describes a valid configuration.
+func (c *config) validate() error {
+	if c.suspicionJitter < 0 || c.suspicionJitter >= 1 {
+		return errors.New("suspicion jitter out of range")
+	}
+	if c.handlerConcurrency < 0 {
+		return errors.New("handler concurrency out of range")
+	}
+	if c.hysteresis < 1 {
+		return errors.New("suspicion hysteresis out of range")
+	}
+	if c.smallSize < 0 || c.smallSize > 0 && c.smallMisses < 1 {
+		return errors.New("small network misses out of range")
+	}
+	if c.receiveBufferSize < minReceiveBufferSize || c.receiveBufferSize > maxReceiveBufferSize {
+		return errors.New("receive buffer size out of range")
+	}
+	if c.quarantine < 0 {
+		return errors.New("quarantine out of range")
+	}
+	if (c.removedCapacity != 0 || c.removedFPRate != 0) &&
+		(c.removedCapacity < 1 || c.removedFPRate <= 0 || c.removedFPRate >= 1) {
+		return errors.New("removed filter parameters out of range")
+	}
+	if c.readBuffer < 0 {
+		return errors.New("socket read buffer size out of range")
+	}
+	if c.writeBuffer < 0 {
+		return errors.New("socket write buffer size out of range")
+	}
+	if c.idBytes < minIDBytes || c.idBytes > maxIDBytes {
+		return errors.New("ID length out of range")
+	}
+	if c.indirectTimeout < 0 || pingTimeout+c.indirectTimeout >= minTickPeriod {
+		return errors.New("indirect timeout out of range")
+	}
+	if c.memoBudget < 0 {
+		return errors.New("memo budget out of range")
+	}
+	if c.memoPriority != FewestSentFirst && c.memoPriority != NewestFirst {
+		return errors.New("invalid memo priority")
+	}
+	if c.rejoinGrace < 0 {
+		return errors.New("rejoin grace period out of range")
+	}
+	if c.maxIdle < 0 {
+		return errors.New("maximum idle periods out of range")
+	}
+	if c.maxMembers < 0 || c.maxMembers == 1 {
+		return errors.New("maximum members out of range")
+	}
+	if c.gossipFanout < 0 {
+		return errors.New("gossip fanout out of range")
+	}
+	if c.probesPerPeriod < 1 {
+		return errors.New("probes per period out of range")
+	}
+	if c.retransmitMult < 1 {
+		return errors.New("retransmit multiplier out of range")
+	}
+	if c.maxPacketSize < minPacketSize || c.maxPacketSize > maxReceiveBufferSize {
+		return errors.New("maximum packet size out of range")
+	}
+	if _, ok := c.conn.(Transport); c.conn != nil && !ok {
+		return errors.New("conn does not implement Transport")
+	}
+	return nil
+}
+
+// WithSuspicionJitter sets the maximum fraction f by which a Node's suspicion
+// timeout differs from the nominal value. Each Node scales its timeout by a
+// random factor within f of 1, so that peers do not all declare a failed node
+// failed in the same protocol period. The default is 0.1; f must be at least
+// 0 and less than 1.
+func WithSuspicionJitter(f float64) Option {
+	return func(c *config) { c.suspicionJitter = f }
+}
+
+// WithSyncHandlers causes a Node to call its join, memo, and failure handlers
+// one at a time, in the order in which the corresponding events occur, from a
+// single goroutine. By default, each handler call runs in its own goroutine,
+// subject only to the ordering guarantees documented by OnMemo and OnFail.
+//
+// A Node using synchronous handlers queues pending handler calls without
+// limit. Handlers may call the Node's methods, but if a handler blocks,
+// subsequent calls wait for it to return while the queue grows, so handlers
+// should return promptly.
+func WithSyncHandlers() Option {
+	return WithHandlerConcurrency(1)
+}
+
+// WithHandlerConcurrency limits a Node to k concurrent handler calls. Pending
+// calls are queued and started in the order in which the corresponding events
+// occur, and the ordering guarantees documented by OnMemo and OnFail continue
+// to hold. As with WithSyncHandlers, the queue grows without limit while
+// handlers fall behind, so they should return promptly. k must be positive;
+// WithHandlerConcurrency(1) is equivalent to WithSyncHandlers.
+func WithHandlerConcurrency(k int) Option {
+	return func(c *config) { c.handlerConcurrency = k }
+}
+
+// WithSuspicionHysteresis dampens the suspicion of peers on unreliable links.
+// Once a peer has refuted suspicion, a Node with hysteresis k does not
+// suspect it again until it fails to respond to k consecutive probes, unless
+// it first responds to k consecutive probes, after which a single missed
+// probe suffices once more. Suspicion itself is always cleared by refutation,
+// as the protocol requires. The default is 1, which disables hysteresis; k
+// must be positive.
+func WithSuspicionHysteresis(k int) Option {
+	return func(c *config) { c.hysteresis = k }
+}
+
+// WithSmallNetworkMisses causes a Node in a network of fewer than size nodes,
+// including itself, to suspect a peer only once k consecutive probes of it go
+// unacknowledged. In so small a network there are few or no other peers to
+// probe a peer indirectly when its ack is lost, so a single lost packet
+// would otherwise suffice for suspicion, and SWIM's probabilistic guarantees
+// do not apply. Requiring more misses reduces false positives in such
+// networks at the cost of detecting genuine failures k-1 protocol periods
+// later. For example, WithSmallNetworkMisses(3, 2) tolerates a lost ack
+// between the two nodes of a two-node network. By default, a Node suspects a
+// peer after a single missed probe whatever the size of the network; size
+// must not be negative, and k must be positive if size is.
+func WithSmallNetworkMisses(size, k int) Option {
+	return func(c *config) {
+		c.smallSize = size
+		c.smallMisses = k
+	}
+}
+
+// WithReceiveBufferSize sets the size in bytes of the buffer into which a
+// Node reads incoming packets. The default is 65535, the maximum size of a
+// UDP datagram; size must be at least 1280, the minimum MTU of an IPv6 link.
+// A Node reuses a single buffer for all reads. Because datagrams larger than
+// the buffer are truncated, a Node discards any packet that fills the buffer
+// entirely.
+func WithReceiveBufferSize(size int) Option {
+	return func(c *config) { c.receiveBufferSize = size }
+}
+
+// WithReadBufferSize sets the size in bytes of the operating system's receive
+// buffer for a Node's socket, which holds packets that have arrived but that
+// the Node has yet to read. It is distinct from the buffer set by
+// WithReceiveBufferSize, which holds one packet at a time. A larger buffer
+// reduces packet loss when many packets arrive at once, as when news spreads
+// through a large network; a few hundred kilobytes to a few megabytes suits
+// most networks. The default is 0, which leaves the operating system's
+// default in place; size must not be negative.
+//
+// The operating system may limit the size; on Linux, for example, sizes
+// beyond net.core.rmem_max are reduced silently. Start returns an error if the
+// size cannot be set. The option has no effect on Nodes started by a Mux.
+func WithReadBufferSize(size int) Option {
+	return func(c *config) { c.readBuffer = size }
+}
+
+// WithWriteBufferSize sets the size in bytes of the operating system's send
+// buffer for a Node's socket, which holds packets that the Node has written
+// but that have yet to be transmitted. A larger buffer reduces the number of
+// packets dropped because the buffer is full, which Stats reports as
+// SendsDropped. The default is 0, which leaves the operating system's default
+// in place; size must not be negative.
+//
+// As with WithReadBufferSize, the operating system may limit the size (on
+// Linux, to net.core.wmem_max), and the option has no effect on Nodes started
+// by a Mux.
+func WithWriteBufferSize(size int) Option {
+	return func(c *config) { c.writeBuffer = size }
+}
+
+// WithConn causes Start and StartAddrPort to create a Node that sends and
+// receives packets through conn, which the caller has already bound and
+// configured, rather than listening on the given address. It lets an
+// application set socket options that the package does not expose, such as
+// SO_REUSEPORT or IP_TOS. conn must implement Transport, as *net.UDPConn
+// does. WithReadBufferSize and WithWriteBufferSize apply to conn if it has
+// SetReadBuffer and SetWriteBuffer methods. The Node closes conn when it
+// shuts down.
+func WithConn(conn net.PacketConn) Option {
+	return func(c *config) { c.conn = conn }
+}
+
+// WithMaxPacketSize sets the maximum size in bytes of the packets a Node
+// sends, which should not exceed the path MTU of the network less the size of
+// the IP and UDP headers. If a packet would exceed the limit, the Node omits
+// as many of its least important messages as necessary and reports the
+// omission to its error handlers. The default is 1400; size must be at least
+// 576. Limits much lower than the default can prevent the delivery of long
+// memos.
+func WithMaxPacketSize(size int) Option {
+	return func(c *config) { c.maxPacketSize = size }
+}
+
+// WithClusterName labels the packets a Node sends with the given cluster
+// name. A Node ignores packets labeled with a different name, including
+// unlabeled packets if name is not empty, so that nodes belonging to separate
+// networks do not interfere with one another. The default is the empty name.
+func WithClusterName(name string) Option {
+	return func(c *config) { c.clusterName = name }
+}
+
+// WithSuspicion controls whether a Node suspects a peer that fails to
+// acknowledge a probe before declaring it failed. Suspicion is enabled by
+// default, as the protocol requires. If it is disabled, a Node declares an
+// unresponsive peer failed as soon as the probe expires, without giving the
+// peer a chance to refute suspicion.
+//
+// Disabling suspicion speeds up failure detection at the cost of a
+// dramatically higher rate of false positives: a single lost packet or a
+// briefly overloaded peer is enough to eject a healthy member from the
+// network. It should only be considered on fast, reliable local networks,
+// never across a WAN.
+func WithSuspicion(enabled bool) Option {
+	return func(c *config) { c.suspicion = enabled }
+}
+
+// WithSuspectMemos sets whether a Node delivers memos from peers it suspects
+// of having failed. A suspected peer is likely to be on its way out of the
+// network, so an application may prefer not to act on its memos. A Node that
+// declines a memo from a suspect neither delivers nor disseminates it, but
+// delivers it if it receives it again once the suspicion is refuted. The
+// default is true.
+func WithSuspectMemos(deliver bool) Option {
+	return func(c *config) { c.suspectMemos = deliver }
+}
+
+// WithSelfMemoDelivery causes a Node to deliver the memos it posts to its own
+// memo and update handlers, as well as disseminating them, so that an
+// application can treat every memo alike, whichever node posted it. The
+// Node's own memos are reported with its ID and the address set by
+// UpdateAddr, if any. By default, a Node does not deliver its own memos.
+func WithSelfMemoDelivery() Option {
+	return func(c *config) { c.selfMemos = true }
+}
+
+// WithGossipFanout causes a Node to send the membership messages and memos
+// awaiting dissemination to up to k random peers in each protocol period, in
+// addition to piggybacking them on its probe as the protocol prescribes.
+// Gossiping to more peers speeds up convergence in large networks at the cost
+// of bandwidth. Each packet carries no more messages than a probe would, and
+// messages are still retired once sent the usual number of times, so the
+// additional packets stop once the Node has nothing left to disseminate. The
+// default is 0; k must not be negative.
+func WithGossipFanout(k int) Option {
+	return func(c *config) { c.gossipFanout = k }
+}
+
+// WithProbesPerPeriod causes a Node to probe k distinct peers in each
+// protocol period, or every peer if it has fewer than k. Each probe is made
+// as usual, with ping requests sent for each peer that does not acknowledge
+// directly, and peers are still chosen in round-robin order, so each is
+// probed once every len(members)/k periods. Probing more peers detects
+// failures sooner at the cost of bandwidth. The default is 1; k must be at
+// least 1.
+func WithProbesPerPeriod(k int) Option {
+	return func(c *config) { c.probesPerPeriod = k }
+}
+
+// WithCompression causes a Node to compress the packets it sends, which
+// reduces bandwidth at the cost of CPU time when packets carry many messages
+// or long memos. Short packets, and packets that compression would not make
+// shorter, are sent uncompressed. Nodes accept compressed packets whether or
+// not they use compression themselves, so nodes with and without it can
+// participate in the same network.
+func WithCompression() Option {
+	return func(c *config) { c.compression = true }
+}
+
+// WithIDLength sets the number of random bytes in a Node's ID, which is
+// encoded in base32 and so is 8/5 as many characters long. Longer IDs make
+// collisions less likely in very large networks, while shorter IDs are more
+// compact in logs. The default is 15; bytes must be between 5 and 40. All
+// nodes in a network should use the same length: IDs of different lengths
+// do not affect the protocol's correctness, but make logs harder to read.
+func WithIDLength(bytes int) Option {
+	return func(c *config) { c.idBytes = bytes }
+}
+
+// WithMaxIdle causes a Node to suspect any peer from which it has not received
+// an acknowledgment directly in the last k protocol periods, even if the peer
+// has acknowledged probes indirectly through other peers. This catches peers
+// that are only partly reachable, which the protocol would otherwise consider
+// healthy indefinitely. A peer that refutes such suspicion is not suspected
+// for being idle again for another k periods. The default is 0, which
+// disables the policy; k must not be negative.
+//
+// Because a Node probes each peer only once per round of probes, k should be
+// several times the size of the network. Lower values, and unreliable
+// networks, cause healthy peers to be suspected, increasing the rate of false
+// positives.
+func WithMaxIdle(k int) Option {
+	return func(c *config) { c.maxIdle = k }
+}
+
+// WithRetransmitMultiplier sets the multiplier m with which a Node scales the
+// number of times it sends each membership message: m*log(n) rounded up,
+// where n is the size of the network. It also scales the number of times the
+// Node sends each memo, unless WithMemoBudget sets that number directly. A
+// larger multiplier makes it more likely that every peer hears each message
+// when packets are lost, at the cost of bandwidth; a smaller one saves
+// bandwidth, but news may fail to reach some peers, which then learn of it
+// only when they probe the member concerned. The multiplier does not affect
+// how long a Node waits before declaring a suspected peer failed, which the
+// FailureDetector determines; see WithFailureDetector. The default is 2; m
+// must be at least 1.
+func WithRetransmitMultiplier(m int) Option {
+	return func(c *config) { c.retransmitMult = m }
+}
+
+// WithMemoBudget sets the number of times a Node sends each memo it posts or
+// receives, independently of the number of times it sends membership
+// messages. A Node sends at most one memo per packet, so a smaller budget
+// limits the bandwidth a flood of memos consumes, at the risk that some peers
+// never receive them. The default is 0, which sends memos as many times as
+// membership messages, a number that grows logarithmically with the size of
+// the network; k must not be negative.
+func WithMemoBudget(k int) Option {
+	return func(c *config) { c.memoBudget = k }
+}
+
+// WithMemoPriority sets the order in which a Node sends the memos it is
+// disseminating. The default is FewestSentFirst.
+func WithMemoPriority(o MemoPriority) Option {
+	return func(c *config) { c.memoPriority = o }
+}
+
+// WithObserverMode causes a Node to observe the network without joining it.
+// An observer learns the membership of the network and receives memos, but
+// never announces itself, so its peers neither add it to their membership
+// lists nor probe it. To learn the membership, an observer probes each peer it
+// knows of in turn, and each peer responds with its own status and that of a
+// sample of its members. An observer neither disseminates membership
+// information nor suspects peers itself, relying instead on the reports of
+// members, and it cannot post memos.
+//
+// Like any other Node, an observer must be given a seed with Join in order to
+// start receiving membership information.
+func WithObserverMode() Option {
+	return func(c *config) { c.observer = true }
+}
+
+// WithIndirectTimeout sets how long a Node waits for acknowledgments of the
+// indirect probes it requests when a peer fails to acknowledge a direct probe
+// within 200 milliseconds, after which it suspects the peer. Indirect probes
+// take two extra hops, so on high-latency networks their budget may need to
+// differ from the direct probe's. The default is 0, which allows indirect
+// probes until the end of the protocol period, about 800 milliseconds later;
+// d must be less than 700 milliseconds, so that the deadline falls within the
+// shortest possible period.
+func WithIndirectTimeout(d time.Duration) Option {
+	return func(c *config) { c.indirectTimeout = d }
+}
+
+// WithAdvertiseFunc causes a Node to advertise the address f returns as its
+// own in the packets it sends to the peer at the address peer, for use on
+// multi-homed hosts whose reachable address depends on the network from which
+// a peer connects. If f returns the zero value, the Node advertises no
+// address, and peers use the source address from which its packets arrive, as
+// they do by default. Peers relay a Node's status with whatever address they
+// last received, so f should return an address reachable by any peer that
+// could learn of it that way. f may be called from multiple goroutines at
+// once, and must not block.
+func WithAdvertiseFunc(f func(peer netip.AddrPort) netip.AddrPort) Option {
+	return func(c *config) { c.advertise = f }
+}
+
+// WithFailureDetector sets the FailureDetector that determines how long a
+// Node waits before declaring a suspected peer failed and how many peers it
+// asks to probe an unresponsive one. By default, a Node waits a number of
+// protocol periods that grows logarithmically with the size of the network,
+// as the protocol prescribes, and requests two indirect probes. A nil d
+// selects the default. See Lifeguard for an alternative that reduces false
+// positives.
+func WithFailureDetector(d FailureDetector) Option {
+	return func(c *config) { c.detector = d }
+}
+
+// WithPacketTrace causes a Node to call f with each packet it sends or
+// receives, once decoded, for debugging. addr is the address of the peer to
+// which the packet was sent or from which it was received. Received packets
+// are traced before they are processed; packets that cannot be decoded or are
+// labeled with another cluster name are not traced. Sent packets are
+// traced as sent, after any messages have been omitted to limit their size.
+// f is called from multiple goroutines, while the Node sends or receives, and
+// so should return promptly. By default, packets are not traced.
+func WithPacketTrace(f func(dir Direction, addr netip.AddrPort, p TracePacket)) Option {
+	return func(c *config) { c.tracer = f }
+}
+
+// WithMaxMembers limits the size of the network as seen by a Node, including
+// the Node itself, to k members. Once the limit is reached, the Node ignores
+// news of peers it does not already know of, neither adding them to its
+// membership list nor disseminating the news, until existing members leave.
+// News of known members is processed as usual. The number of messages
+// ignored is reported in Stats. The default is 0, which imposes no limit; k
+// must not be negative or 1.
+//
+// The limit guards against resource exhaustion, but it fails closed: nodes
+// that reach the limit at different times can admit different peers, so
+// their membership lists diverge, and a peer turned away by some nodes may
+// still be admitted by others. All nodes in a network should use the same
+// limit, and it should comfortably exceed the expected size of the network.
+func WithMaxMembers(k int) Option {
+	return func(c *config) { c.maxMembers = k }
+}
+
+// WithJoinFilter sets a function that decides whether a Node admits a peer it
+// does not already know of. The Node calls f with the peer's ID and address
+// when it first hears that the peer is alive or suspected; if f returns false,
+// the Node ignores the news, neither adding the peer to its membership list
+// nor disseminating the news. News of known members is processed as usual.
+// The number of messages ignored is reported in Stats.
+//
+// f is called with the Node's lock held, so it must not call the Node's
+// methods, and it should return quickly. It may be called again for the same
+// peer whenever the Node hears of it.
+//
+// Each Node applies its own filter. If nodes disagree about which peers to
+// admit, their membership lists diverge, and a peer turned away by some nodes
+// may still be admitted by others, which can fragment the network. All nodes
+// in a network should apply the same policy.
+func WithJoinFilter(f func(id string, addr netip.AddrPort) bool) Option {
+	return func(c *config) { c.joinFilter = f }
+}
+
+// WithProbeSeed sets the seed of the source of random numbers that determines
+// the order in which a Node probes its peers. By default, each Node derives
+// the seed from its ID, so that the orders of different Nodes are
+// uncorrelated. A fixed seed makes the order reproducible, which can be useful
+// in tests.
+func WithProbeSeed(seed int64) Option {
+	return func(c *config) { c.probeSeed = &seed }
+}
+
+// WithQuarantine sets how long a Node ignores a peer after removing it from
+// the network. Until the quarantine ends, the Node discards the peer's
+// packets, so that a peer declared failed does not immediately rejoin. After
+// it ends, the peer can rejoin by contacting the Node directly, which lets a
+// peer that was declared failed mistakenly, for example during a network
+// partition, return once the network heals. News of the peer from other
+// members continues to be ignored until the peer rejoins. The default is 30
+// seconds; d must not be negative.
+func WithQuarantine(d time.Duration) Option {
+	return func(c *config) { c.quarantine = d }
+}
+
+// WithRemovedFilter causes a Node to remember the peers it has removed from
+// the network in a pair of rotating Bloom filters rather than exactly, which
+// bounds the memory a long-running Node in a large network spends on them.
+// The Node remembers at least the capacity most recently removed peers, and
+// forgets older ones once the filters rotate. p is the probability that the
+// Node mistakes a peer for one it removed recently when a filter is full; a
+// filter needs about 1.44*log₂(1/p) bits per peer, so a capacity of 100,000
+// and p of 0.001 take about 180 kilobytes each.
+//
+// A Node that mistakes a peer for a removed one ignores news of it from other
+// members, and ignores the peer itself until a quarantine has passed, so a
+// new or returning peer may take longer to join. The filters do not record
+// exactly when each peer was removed, so a removed peer's quarantine may also
+// last longer than set by WithQuarantine, by up to the time between the
+// removals that fill a filter. Conversely, once a filter rotates out, stale
+// news of a peer removed long ago may be taken as news of a new peer, which
+// is subsequently declared failed anew. The default is to remember every
+// removed peer exactly; capacity must be positive, and p greater than 0 and
+// less than 1.
+func WithRemovedFilter(capacity int, p float64) Option {
+	return func(c *config) {
+		c.removedCapacity = capacity
+		c.removedFPRate = p
+	}
+}
+
+// WithSeeds sets the addresses of nodes through which a Node rejoins the
+// network if it removes all of its members, as it does when a network
+// partition isolates it for long enough that it declares every peer failed.
+// While isolated, the Node sends a join request to each seed, first after
+// the grace period set by WithRejoinGrace and then at intervals that double
+// up to about 30 seconds, until it has a member again. It also forgets the
+// peers it removed, so that it can learn of those that survived from its
+// seeds. A Node that has left the network does not rejoin. By default, an
+// isolated Node remains alone until it is told to Join.
+//
+// A seed that was not itself isolated may have removed the Node, in which
+// case it ignores the Node's requests until its quarantine ends; see
+// WithQuarantine.
+func WithSeeds(seeds ...netip.AddrPort) Option {
+	return func(c *config) { c.rejoinSeeds = append([]netip.AddrPort(nil), seeds...) }
+}
+
+// WithRejoinGrace sets the number of protocol periods k that a Node waits
+// after losing all of its members before it attempts to rejoin the network
+// through the seeds set by WithSeeds. A grace period gives a Node that lost
+// its members to a brief partition the chance to be rejoined by a peer
+// instead, and spares the seeds a burst of join requests when many nodes are
+// isolated at once. The default is 0, which attempts to rejoin immediately;
+// k must not be negative.
+func WithRejoinGrace(k int) Option {
+	return func(c *config) { c.rejoinGrace = k }
+}
+
+// WithKnownSendersOnly causes a Node to ignore packets from peers that are not
+// members of its network, unless they are sent from one of the trusted
+// addresses or from an address the Node has been asked to Join. News of new
+// peers carried by members' packets is processed as usual, so the network can
+// still grow, but a stranger cannot introduce itself by contacting the Node
+// directly. The number of packets ignored is reported in Stats.
+//
+// Because a Node joining the network is a stranger to the nodes it contacts,
+// each node's trusted addresses should include those of the nodes expected to
+// join through it, or the joining node must be introduced by a node that
+// already knows it. By default, a Node accepts packets from any sender.
+func WithKnownSendersOnly(trusted ...netip.AddrPort) Option {
+	return func(c *config) {
+		c.knownOnly = true
+		c.trustedAddrs = append([]netip.AddrPort(nil), trusted...)
+	}
+}
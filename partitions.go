@@ -0,0 +1,36 @@
+package swim
+
+import "sort"
+
+// A PartitionInfo describes a peer that a Node can reach only indirectly,
+// suggesting a one-way or asymmetric partition between the two.
+type PartitionInfo struct {
+	ID     string   // the peer
+	Relays []string // peers that relayed the peer's acks, sorted
+	Probes int      // probes that succeeded only indirectly since the last direct ack
+}
+
+// SuspectedPartitions returns the peers, sorted by ID, whose direct probes by
+// n have consistently gone unacknowledged while the indirect probes relayed
+// by other peers succeeded. Ordinary SWIM treats such peers as healthy, so
+// this is useful diagnostic information about the network. A peer is no
+// longer reported once it acknowledges a direct probe, or if the evidence
+// does not recur for several rounds of probes.
+func (n *Node) SuspectedPartitions() []PartitionInfo {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var ps []PartitionInfo
+	for id, e := range n.fsm.partitions {
+		if e.probes < minPartitionProbes {
+			continue
+		}
+		p := PartitionInfo{ID: string(id), Probes: e.probes}
+		for r := range e.relays {
+			p.Relays = append(p.Relays, string(r))
+		}
+		sort.Strings(p.Relays)
+		ps = append(ps, p)
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].ID < ps[j].ID })
+	return ps
+}
@@ -0,0 +1,167 @@
+package swim
+
+import (
+	"hash/fnv"
+	"math"
+	"time"
+)
+
+// A removedSet records the ids a state machine has removed and when it
+// removed them.
+type removedSet interface {
+	// add records that id was removed at time t.
+	add(id id, t time.Time)
+
+	// when returns the time at which id was removed, or a later time, and
+	// reports whether id was removed and has not been forgotten since.
+	when(id id) (time.Time, bool)
+
+	// forget ceases to record that id was removed.
+	forget(id id)
+
+	// reset forgets every removed id.
+	reset()
+}
+
+// removedMap is a removedSet that records each removed id exactly.
+type removedMap map[id]time.Time
+
+func (r removedMap) add(id id, t time.Time) { r[id] = t }
+
+func (r removedMap) when(id id) (time.Time, bool) {
+	t, ok := r[id]
+	return t, ok
+}
+
+func (r removedMap) forget(id id) { delete(r, id) }
+
+func (r removedMap) reset() {
+	for id := range r {
+		delete(r, id)
+	}
+}
+
+// A removedFilter is a removedSet that records removed ids in a pair of
+// Bloom filters, which use a fixed amount of memory however many ids are
+// removed. Once the current filter holds capacity ids, it replaces the
+// previous one and a new filter begins, so each id is remembered for at least
+// capacity subsequent removals and at most twice as many.
+//
+// A removedFilter may report that an id was removed when it was not, and
+// reports each id as removed at the time of the most recent removal recorded
+// in the same filter, which may be later than the id's own removal. Neither
+// error can cause a removed id to be forgotten early.
+type removedFilter struct {
+	capacity int
+	size     int // bits in each filter
+	hashes   int
+	cur      *bloomFilter
+	prev     *bloomFilter
+	forgiven map[id]bool // ids forgotten since they were added to a filter
+}
+
+// newRemovedFilter returns a removedFilter that remembers at least capacity
+// ids, with false positive rate p while each filter is full.
+func newRemovedFilter(capacity int, p float64) *removedFilter {
+	// A filter of m bits holding n ids has the minimum false positive rate
+	// p when m = -n ln p / (ln 2)², using k = (m/n) ln 2 hash functions
+	bits := int(math.Ceil(-float64(capacity) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	hashes := int(math.Round(float64(bits) / float64(capacity) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &removedFilter{
+		capacity: capacity,
+		size:     bits,
+		hashes:   hashes,
+		cur:      newBloomFilter(bits),
+		prev:     newBloomFilter(bits),
+		forgiven: make(map[id]bool),
+	}
+}
+
+func (r *removedFilter) add(id id, t time.Time) {
+	if r.cur.n == r.capacity {
+		r.prev, r.cur = r.cur, newBloomFilter(r.size)
+		for id := range r.forgiven {
+			if !r.prev.has(id, r.hashes) {
+				delete(r.forgiven, id)
+			}
+		}
+	}
+	delete(r.forgiven, id)
+	r.cur.add(id, r.hashes, t)
+}
+
+func (r *removedFilter) when(id id) (time.Time, bool) {
+	switch {
+	case r.forgiven[id]:
+		return time.Time{}, false
+	case r.cur.has(id, r.hashes):
+		return r.cur.last, true
+	case r.prev.has(id, r.hashes):
+		return r.prev.last, true
+	}
+	return time.Time{}, false
+}
+
+func (r *removedFilter) forget(id id) {
+	if _, ok := r.when(id); ok {
+		r.forgiven[id] = true
+	}
+}
+
+func (r *removedFilter) reset() {
+	r.cur, r.prev = newBloomFilter(r.size), newBloomFilter(r.size)
+	r.forgiven = make(map[id]bool)
+}
+
+// A bloomFilter is a Bloom filter of ids.
+type bloomFilter struct {
+	bits []uint64
+	n    int       // number of ids added
+	last time.Time // time of the most recent addition
+}
+
+// newBloomFilter returns an empty bloomFilter of at least the given number
+// of bits.
+func newBloomFilter(bits int) *bloomFilter {
+	return &bloomFilter{bits: make([]uint64, (bits+63)/64)}
+}
+
+// add adds id to f at time t, using k hash functions.
+func (f *bloomFilter) add(id id, k int, t time.Time) {
+	m := uint64(len(f.bits) * 64)
+	h1, h2 := hashID(id)
+	for i := 0; i < k; i++ {
+		b := (h1 + uint64(i)*h2) % m
+		f.bits[b/64] |= 1 << (b % 64)
+	}
+	f.n++
+	f.last = t
+}
+
+// has reports whether id may have been added to f using k hash functions.
+func (f *bloomFilter) has(id id, k int) bool {
+	m := uint64(len(f.bits) * 64)
+	h1, h2 := hashID(id)
+	for i := 0; i < k; i++ {
+		b := (h1 + uint64(i)*h2) % m
+		if f.bits[b/64]&(1<<(b%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hashID returns two hashes of id, from which a Bloom filter derives its
+// hash functions by double hashing.
+func hashID(id id) (h1, h2 uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	sum := h.Sum64()
+	// An odd second hash visits distinct bits for each hash function as
+	// long as the number of bits is a power of two, and rarely repeats
+	// otherwise
+	return sum, sum>>32 | sum<<32 | 1
+}
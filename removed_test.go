@@ -0,0 +1,79 @@
+package swim
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRemovedFilter(t *testing.T) {
+	const capacity, p = 1000, 0.01
+	r := newRemovedFilter(capacity, p)
+	start := time.Now()
+	batch := func(b int) []id {
+		ids := make([]id, capacity)
+		for i := range ids {
+			ids[i] = id(fmt.Sprintf("%v-%v", b, i))
+		}
+		return ids
+	}
+	// found returns the number of ids that r reports as removed.
+	found := func(ids []id) (n int) {
+		for _, id := range ids {
+			if _, ok := r.when(id); ok {
+				n++
+			}
+		}
+		return n
+	}
+
+	first := batch(0)
+	for i, id := range first {
+		r.add(id, start.Add(time.Duration(i)*time.Second))
+	}
+	for i, id := range first {
+		tm, ok := r.when(id)
+		if !ok {
+			t.Fatalf("removed id %v not found", id)
+		}
+		if removed := start.Add(time.Duration(i) * time.Second); tm.Before(removed) {
+			t.Fatalf("%v: got removal time %v, expected no earlier than %v", id, tm, removed)
+		}
+	}
+	if n := found(batch(-1)); n > 2*p*capacity {
+		t.Errorf("%v false positives among %v ids, expected about %v", n, capacity, p*capacity)
+	}
+
+	// A forgotten id is not found until it is removed again
+	r.forget(first[0])
+	if _, ok := r.when(first[0]); ok {
+		t.Error("forgotten id found")
+	}
+
+	// Each id is remembered for at least capacity later removals, and
+	// forgotten after twice as many
+	for _, id := range batch(1) {
+		r.add(id, start)
+	}
+	if n := found(first[1:]); n != capacity-1 {
+		t.Errorf("found %v of %v ids removed before one rotation", n, capacity-1)
+	}
+	if _, ok := r.when(first[0]); ok {
+		t.Error("forgotten id found after rotation")
+	}
+	r.add(first[0], start)
+	if _, ok := r.when(first[0]); !ok {
+		t.Error("id removed again not found")
+	}
+	for _, id := range batch(2) {
+		r.add(id, start)
+	}
+	if n := found(first[1:]); n > 4*p*capacity {
+		t.Errorf("found %v of %v ids removed before two rotations", n, capacity-1)
+	}
+
+	r.reset()
+	if n := found(batch(2)); n != 0 {
+		t.Errorf("found %v ids after reset", n)
+	}
+}
@@ -0,0 +1,483 @@
+package swim
+
+import (
+	"encoding/json"
+	"hash"
+	"hash/fnv"
+	"math/rand"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+const (
+	// simSteps is the number of steps in a simulated protocol period.
+	simSteps = 5
+
+	// simTimeoutStep is the step of each period in which probes time out.
+	simTimeoutStep = 1
+)
+
+// A sim is a deterministic simulation of a network of state machines, which
+// exchange packets subject to loss and latency and are stepped in lockstep.
+// The nodes' ids, sources of randomness, and clocks all derive from the sim's
+// seed, so a given seed always produces the same run.
+type sim struct {
+	rand    *rand.Rand
+	loss    float64 // probability that a packet is lost
+	latency int     // steps a packet takes to arrive
+
+	step     int
+	nodes    []*simNode
+	byAddr   map[netip.AddrPort]*simNode
+	inflight []simPacket
+	sent     hash.Hash64 // hash of every packet sent, for comparing runs
+}
+
+// A simNode is a state machine participating in a sim.
+type simNode struct {
+	s       *stateMachine
+	addr    netip.AddrPort
+	down    bool // whether the node has crashed or left the network
+	members map[id]bool
+	seed    *simNode // node to join, until the node has a member
+}
+
+// A simPacket is a packet in transit.
+type simPacket struct {
+	arrival int
+	src     *simNode
+	dst     netip.AddrPort
+	b       []byte
+}
+
+// newSim returns a sim of n state machines, none of which have joined one
+// another.
+func newSim(n int, seed int64, loss float64, latency int) *sim {
+	sm := &sim{
+		rand:    rand.New(rand.NewSource(seed)),
+		loss:    loss,
+		latency: latency,
+		byAddr:  make(map[netip.AddrPort]*simNode),
+		sent:    fnv.New64a(),
+	}
+	for i := 0; i < n; i++ {
+		sn := &simNode{
+			addr:    netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), uint16(10000+i)),
+			members: make(map[id]bool),
+		}
+		sn.s = newStateMachine(
+			func(id id, _ netip.AddrPort) { sn.members[id] = true },
+			func(id, netip.AddrPort, []byte) {},
+			func(id id, _ FailReason) { delete(sn.members, id) },
+		)
+		sn.s.id = readID(sm.rand, defaultIDBytes)
+		sn.s.now = sm.now
+		sn.s.order.SetRand(rand.New(rand.NewSource(sm.rand.Int63())))
+		sm.nodes = append(sm.nodes, sn)
+		sm.byAddr[sn.addr] = sn
+	}
+	return sm
+}
+
+// now returns the simulated time: one protocol period per simSteps steps,
+// since an arbitrary fixed epoch.
+func (sm *sim) now() time.Time {
+	return time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(sm.step) * tickAverage / simSteps)
+}
+
+// join causes node i to join node j. Like Node.JoinAndWait, node i resends
+// its join request every period until it has a member.
+func (sm *sim) join(i, j int) {
+	sm.nodes[i].seed = sm.nodes[j]
+	sm.sendJoin(sm.nodes[i])
+}
+
+// sendJoin sends a join request from sn to its seed.
+func (sm *sim) sendJoin(sn *simNode) {
+	sm.send(sn, sn.s.makeJoin(sn.seed.addr))
+}
+
+// send puts p in transit from src, unless it is lost. Packets are encoded and
+// decoded as on the wire so that state machines share no messages.
+func (sm *sim) send(src *simNode, p packet) {
+	if sm.rand.Float64() < sm.loss {
+		return
+	}
+	b, err := json.Marshal(envelope{SrcID: src.s.id, P: p})
+	if err != nil {
+		panic(err)
+	}
+	sm.sent.Write(b)
+	sm.inflight = append(sm.inflight, simPacket{
+		arrival: sm.step + sm.latency,
+		src:     src,
+		dst:     p.remoteAddr,
+		b:       b,
+	})
+}
+
+// run advances the simulation by the given number of protocol periods.
+func (sm *sim) run(periods int) {
+	for i := 0; i < periods*simSteps; i++ {
+		sm.advance()
+	}
+}
+
+// advance advances the simulation by one step.
+func (sm *sim) advance() {
+	for _, sn := range sm.nodes {
+		if sn.down {
+			continue
+		}
+		switch sm.step % simSteps {
+		case 0:
+			if sn.seed != nil && len(sn.members) == 0 {
+				sm.sendJoin(sn)
+			}
+			sm.sendAll(sn, sn.s.tick())
+		case simTimeoutStep:
+			sm.sendAll(sn, sn.s.timeout())
+		}
+	}
+	var pending []simPacket
+	arrived := sm.inflight
+	sm.inflight = nil
+	for _, sp := range arrived {
+		if sp.arrival > sm.step {
+			pending = append(pending, sp)
+			continue
+		}
+		dst, ok := sm.byAddr[sp.dst]
+		if !ok || dst.down {
+			continue
+		}
+		var e envelope
+		if err := json.Unmarshal(sp.b, &e); err != nil {
+			panic(err)
+		}
+		e.P.remoteID = e.SrcID
+		e.P.remoteAddr = sp.src.addr
+		ps, ok := dst.s.receive(e.P)
+		if !ok {
+			dst.down = true
+			continue
+		}
+		sm.sendAll(dst, ps)
+	}
+	sm.inflight = append(pending, sm.inflight...)
+	sm.step++
+}
+
+// introduceAll makes every running node a member of every other running
+// node's network directly, bypassing dissemination.
+func (sm *sim) introduceAll() {
+	for _, sn := range sm.nodes {
+		for _, other := range sm.nodes {
+			if other == sn || sn.down || other.down {
+				continue
+			}
+			m := other.s.aliveMessage()
+			m.Addr = other.addr
+			sn.s.receive(packet{Type: gossip, remoteID: other.s.id, remoteAddr: other.addr, Msgs: []*message{m}})
+		}
+	}
+	for _, sn := range sm.nodes {
+		sn.s.msgQueue.Clear()
+	}
+}
+
+func (sm *sim) sendAll(src *simNode, ps []packet) {
+	for _, p := range ps {
+		sm.send(src, p)
+	}
+}
+
+// converged reports whether every running node has every other running node,
+// and no others, as a member.
+func (sm *sim) converged() bool {
+	var up []*simNode
+	for _, sn := range sm.nodes {
+		if !sn.down {
+			up = append(up, sn)
+		}
+	}
+	for _, sn := range up {
+		if len(sn.members) != len(up)-1 {
+			return false
+		}
+		for _, other := range up {
+			if other != sn && !sn.members[other.s.id] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestSimJoin(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		loss    float64
+		latency int
+	}{
+		{"reliable", 0, 1},
+		{"lossy", 0.05, 1},
+		{"slow", 0, 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := newSim(8, 1, tt.loss, tt.latency)
+			for i := 1; i < len(sm.nodes); i++ {
+				sm.join(i, 0)
+			}
+			sm.run(30)
+			if !sm.converged() {
+				for i, sn := range sm.nodes {
+					t.Logf("node %v: %v members", i, len(sn.members))
+				}
+				t.Fatal("membership did not converge")
+			}
+		})
+	}
+}
+
+func TestSimDeterministic(t *testing.T) {
+	// run joins nodes through a single seed over a lossy network, fails one,
+	// and returns the hash of every packet sent
+	run := func(seed int64) uint64 {
+		sm := newSim(10, seed, 0.1, 2)
+		for i := 1; i < len(sm.nodes); i++ {
+			sm.join(i, 0)
+		}
+		sm.run(15)
+		sm.nodes[3].down = true
+		sm.run(15)
+		return sm.sent.Sum64()
+	}
+	for seed := int64(1); seed <= 5; seed++ {
+		if a, b := run(seed), run(seed); a != b {
+			t.Errorf("seed %v: runs sent different packets", seed)
+		}
+	}
+	if run(1) == run(2) {
+		t.Error("seeds 1 and 2 sent the same packets")
+	}
+}
+
+func TestSimFail(t *testing.T) {
+	sm := newSim(6, 1, 0, 1)
+	for i := 1; i < len(sm.nodes); i++ {
+		sm.join(i, 0)
+	}
+	sm.run(20)
+	if !sm.converged() {
+		t.Fatal("membership did not converge")
+	}
+	sm.nodes[3].down = true
+	sm.run(30)
+	if !sm.converged() {
+		t.Fatal("failure not detected")
+	}
+}
+
+func TestSimProbesPerPeriod(t *testing.T) {
+	// detection returns the mean number of periods, over several seeds,
+	// between a node's failure and the first suspicion of it, when each node
+	// probes k members per period
+	detection := func(k int) float64 {
+		const seeds = 20
+		steps := 0
+		for seed := int64(1); seed <= seeds; seed++ {
+			sm := newSim(16, seed, 0, 1)
+			sm.introduceAll()
+			for _, sn := range sm.nodes {
+				sn.s.probesPerPeriod = k
+			}
+			sm.run(3)
+			failed := sm.nodes[3]
+			failed.down = true
+			for i := 0; i < 40*simSteps; i++ {
+				suspected := false
+				for _, sn := range sm.nodes {
+					suspected = suspected || !sn.down && sn.s.isSuspect(failed.s.id)
+				}
+				if suspected {
+					break
+				}
+				sm.advance()
+				steps++
+			}
+		}
+		return float64(steps) / simSteps / seeds
+	}
+	d1, d3 := detection(1), detection(3)
+	t.Logf("mean periods to detection: %.2f with k=1, %.2f with k=3", d1, d3)
+	if d3 >= d1 {
+		t.Errorf("detection took %.2f periods with k=3, expected less than %.2f with k=1", d3, d1)
+	}
+}
+
+func TestSimObserver(t *testing.T) {
+	sm := newSim(10, 1, 0, 1)
+	observer := sm.nodes[9]
+	observer.s.observer = true
+	for i := 1; i < len(sm.nodes)-1; i++ {
+		sm.join(i, 0)
+	}
+	sm.run(20)
+	sm.join(9, 0)
+	sm.run(30)
+	for i, sn := range sm.nodes[:9] {
+		if sn.members[observer.s.id] {
+			t.Errorf("node %v has observer as a member", i)
+		}
+		if !observer.members[sn.s.id] {
+			t.Errorf("observer does not have node %v as a member", i)
+		}
+	}
+
+	// The observer learns of failures from the members
+	sm.nodes[4].down = true
+	sm.run(30)
+	if observer.members[sm.nodes[4].s.id] {
+		t.Error("observer did not learn of failure")
+	}
+}
+
+func TestSimFailUnderChurn(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		// churn begins the churn among the nodes other than node 3 and
+		// returns a function that continues it each period
+		churn func(sm *sim) func()
+	}{
+		{"joins", func(sm *sim) func() {
+			// New nodes join through various seeds, keeping the
+			// message queues busy with news of members being alive
+			for i := 10; i < len(sm.nodes); i++ {
+				sm.nodes[i].down = false
+				sm.join(i, i%3)
+			}
+			return func() {}
+		}},
+		{"refutations", func(sm *sim) func() {
+			// Members keep the message queues busy with news of
+			// themselves being alive
+			return func() {
+				for _, sn := range sm.nodes[4:] {
+					sn.s.incarnation++
+					sn.s.enqueue(sn.s.aliveMessage())
+				}
+			}
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// run reports the number of periods the network takes to
+			// detect the failure, up to 40, and whether its membership
+			// then converges
+			run := func(seed int64) (periods int, converged bool) {
+				sm := newSim(16, seed, 0, 1)
+				for _, sn := range sm.nodes[10:] {
+					sn.down = true
+				}
+				sm.introduceAll()
+				failed := sm.nodes[3]
+				failed.down = true
+				churn := tt.churn(sm)
+				for ; periods < 40; periods++ {
+					known := false
+					for _, sn := range sm.nodes {
+						known = known || !sn.down && sn.members[failed.s.id]
+					}
+					if !known {
+						break
+					}
+					churn()
+					sm.run(1)
+				}
+				sm.run(20)
+				return periods, sm.converged()
+			}
+			for seed := int64(1); seed <= 5; seed++ {
+				periods, converged := run(seed)
+				t.Logf("seed %v: failure detected by all nodes in %v periods", seed, periods)
+				if periods > 15 {
+					t.Errorf("seed %v: failure took %v periods to detect, expected at most 15", seed, periods)
+				}
+				if !converged {
+					t.Errorf("seed %v: membership did not converge", seed)
+				}
+			}
+		})
+	}
+}
+
+func TestSimRefute(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		sm := newSim(12, seed, 0, 1)
+		sm.introduceAll()
+
+		// Node 0 suspects node 5 mistakenly
+		suspect := sm.nodes[5]
+		sm.nodes[0].s.processMsg(&message{Type: suspected, NodeID: suspect.s.id, Addr: suspect.addr})
+		steps := 0
+		for ; steps < 20*simSteps; steps++ {
+			refuted := true
+			for _, sn := range sm.nodes {
+				if sn == suspect {
+					continue
+				}
+				pr, ok := sn.s.members[suspect.s.id]
+				refuted = refuted && ok && pr.incarnation > 0
+			}
+			if refuted {
+				break
+			}
+			sm.advance()
+		}
+		t.Logf("seed %v: refutation reached all nodes in %v steps", seed, steps)
+		if steps > 3*simSteps {
+			t.Errorf("seed %v: refutation took %v steps to reach all nodes, expected at most %v", seed, steps, 3*simSteps)
+		}
+		if !sm.converged() {
+			t.Errorf("seed %v: membership did not converge", seed)
+		}
+	}
+}
+
+func TestSimRejoin(t *testing.T) {
+	sm := newSim(5, 1, 0, 1)
+	sm.introduceAll()
+	for i, sn := range sm.nodes {
+		seed := sm.nodes[0]
+		if i == 0 {
+			seed = sm.nodes[1]
+		}
+		sn.s.seeds = []netip.AddrPort{seed.addr}
+	}
+
+	// A total partition causes every node to remove all of its members
+	sm.loss = 1
+	sm.run(40)
+	for i, sn := range sm.nodes {
+		if len(sn.members) != 0 || !sn.s.isolated {
+			t.Fatalf("node %v: %v members, isolated %v; expected none and true", i, len(sn.members), sn.s.isolated)
+		}
+	}
+
+	// Once the partition heals, the nodes rejoin through their seeds within
+	// one interval between attempts
+	sm.loss = 0
+	sm.run(maxRejoinInterval + 10)
+	if !sm.converged() {
+		for i, sn := range sm.nodes {
+			t.Logf("node %v: %v members", i, len(sn.members))
+		}
+		t.Fatal("membership did not converge")
+	}
+	for i, sn := range sm.nodes {
+		if sn.s.isolated {
+			t.Errorf("node %v is still isolated", i)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package swim
+
+import "time"
+
+// A stableHandler is a function to be called once the membership has been
+// stable for a duration.
+type stableHandler struct {
+	d     time.Duration
+	f     func()
+	fired bool // whether f has been called since the last change
+}
+
+// OnStable registers f as a stability handler, to be called once the
+// membership of the network known to n has not changed, by any peer joining
+// or leaving, for the duration d. After each change, f is called again once
+// the membership has been stable for d once more. This can be used to wait for
+// the network to converge before redistributing work among its members.
+//
+// n checks for changes at the start of each protocol period, so f may be
+// called up to about one second later than d alone would imply, but never
+// earlier. Stability handlers are called like any other handler.
+// OnStable returns a function that unregisters f.
+func (n *Node) OnStable(d time.Duration, f func()) (unregister func()) {
+	return addHandler(&n.mu, &n.stableHandlers, stableHandler{d: d, f: f})
+}
+
+// checkStable calls any stability handlers whose duration has elapsed since
+// the membership last changed, and returns the time until the next handler's
+// duration will have elapsed, or 0 if there is none.
+func (n *Node) checkStable() time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return 0
+	}
+	now := time.Now()
+	if g := n.fsm.generation; g != n.stableGen {
+		n.stableGen = g
+		n.stableSince = now
+		for _, h := range n.stableHandlers {
+			h.fired = false
+		}
+	}
+	var next time.Duration
+	for _, h := range n.stableHandlers {
+		if h.fired {
+			continue
+		}
+		if wait := n.stableSince.Add(h.d).Sub(now); wait > 0 {
+			if next == 0 || wait < next {
+				next = wait
+			}
+			continue
+		}
+		h.fired = true
+		n.dispatch(h.f)
+	}
+	return next
+}
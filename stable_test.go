@@ -0,0 +1,41 @@
+package swim
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOnStable(t *testing.T) {
+	n, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	stable := make(chan time.Time, 2)
+	n.OnStable(100*time.Millisecond, func() { stable <- time.Now() })
+	select {
+	case <-stable:
+	case <-time.After(3 * tickAverage):
+		t.Fatal("stability handler not called")
+	}
+
+	changed := time.Now()
+	n.receive(packet{
+		Type:     ping,
+		remoteID: "AAA",
+		Msgs:     []*message{{Type: alive, NodeID: "AAA"}},
+	})
+	select {
+	case at := <-stable:
+		if at.Sub(changed) < 100*time.Millisecond {
+			t.Errorf("stability handler called %v after change, expected at least 100ms", at.Sub(changed))
+		}
+	case <-time.After(3 * tickAverage):
+		t.Fatal("stability handler not called again after change")
+	}
+	select {
+	case <-stable:
+		t.Error("stability handler called twice without change")
+	case <-time.After(tickAverage + 200*time.Millisecond):
+	}
+}
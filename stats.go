@@ -0,0 +1,123 @@
+package swim
+
+import "time"
+
+// Stats is a snapshot of a Node's state and activity.
+type Stats struct {
+	Members      int    // number of peers in the network, excluding the Node
+	Suspects     int    // number of peers under suspicion
+	Incarnation  int    // the Node's incarnation number
+	MsgQueueLen  int    // number of membership messages being disseminated
+	MemoQueueLen int    // number of memos being disseminated
+	MemosSent    uint64 // number of times memos have been sent
+
+	MsgsRetired    uint64 // membership messages sent as many times as required
+	MsgsSuperseded uint64 // membership messages replaced by newer information first
+
+	PacketsSent     uint64
+	SendsDropped    uint64 // packets dropped because the socket's send buffer was full
+	PacketsReceived uint64
+	PacketsDropped  uint64 // received packets that were truncated, malformed, or from another cluster
+
+	PacketsRejected uint64 // received packets from invalid source addresses
+	ReadErrors      uint64 // transient errors reading from the connection
+
+	OversizedDropped uint64 // messages omitted from packets to limit their size
+
+	JoinsRejected    uint64 // messages about new peers ignored by WithMaxMembers or WithJoinFilter
+	StrangersIgnored uint64 // packets from non-members ignored by WithKnownSendersOnly
+
+	Uptime    time.Duration
+	SinceTick time.Duration // time since the Node last began a protocol period, or 0 if it has yet to
+}
+
+// counters records a Node's packet activity.
+type counters struct {
+	sent        uint64
+	sendDropped uint64
+	received    uint64
+	dropped     uint64
+	rejected    uint64
+
+	readErrors uint64
+
+	oversized uint64
+}
+
+// Stats returns a snapshot of n's state and activity.
+func (n *Node) Stats() Stats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var sinceTick time.Duration
+	if !n.lastTick.IsZero() {
+		sinceTick = time.Since(n.lastTick)
+	}
+	return Stats{
+		Members:      len(n.fsm.members),
+		Suspects:     len(n.fsm.suspects),
+		Incarnation:  n.fsm.incarnation,
+		MsgQueueLen:  n.fsm.msgQueue.Len(),
+		MemoQueueLen: n.fsm.memoQueue.Len(),
+		MemosSent:    n.fsm.memoSends,
+
+		MsgsRetired:    n.fsm.msgQueue.Counts().Retired,
+		MsgsSuperseded: n.fsm.msgQueue.Counts().Superseded,
+
+		PacketsSent:     n.counters.sent,
+		SendsDropped:    n.counters.sendDropped,
+		PacketsReceived: n.counters.received,
+		PacketsDropped:  n.counters.dropped,
+		PacketsRejected: n.counters.rejected,
+		ReadErrors:      n.counters.readErrors,
+
+		OversizedDropped: n.counters.oversized,
+
+		JoinsRejected:    n.fsm.rejectedJoins,
+		StrangersIgnored: n.fsm.strangers,
+
+		Uptime:    time.Since(n.started),
+		SinceTick: sinceTick,
+	}
+}
+
+// SuspicionTimeout returns the number of protocol periods for which n
+// currently waits before declaring a suspected peer failed, if no other peers
+// have confirmed the suspicion. By default, the timeout grows logarithmically
+// with the size of the network, and differs slightly from node to node
+// according to the suspicion jitter.
+func (n *Node) SuspicionTimeout() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.fsm.suspicionTimeout()
+}
+
+// count increments the counter c, which must be one of n's counters.
+func (n *Node) count(c *uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	*c++
+}
+
+// PeerStats describes how a peer has responded to a Node's probes since the
+// peer's incarnation number last changed, which happens when the peer
+// refutes suspicion of its failure. A high proportion of direct timeouts,
+// particularly with indirect rescues, suggests a lossy or congested link
+// between the Node and the peer.
+type PeerStats struct {
+	PingsSent       uint64 // direct probes of the peer
+	AcksReceived    uint64 // direct probes acknowledged
+	DirectTimeouts  uint64 // direct probes not acknowledged in time, prompting indirect probes
+	IndirectRescues uint64 // probes acknowledged only through other members
+}
+
+// PeerStats returns statistics about n's probes of the peer with the given
+// ID. ok is false if the peer is not a member.
+func (n *Node) PeerStats(nodeID string) (ps PeerStats, ok bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	p, ok := n.fsm.members[id(nodeID)]
+	if !ok {
+		return PeerStats{}, false
+	}
+	return p.stats, true
+}
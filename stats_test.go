@@ -0,0 +1,219 @@
+package swim
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	nodes, chans := launch(2)
+	for _, n := range nodes {
+		defer n.Shutdown()
+	}
+	nodes[1].Join(nodes[0].localAddrPort())
+	<-chans[0]
+	<-chans[1]
+
+	conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(nodes[0].localAddrPort()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("not a packet")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	s := nodes[0].Stats()
+	if s.Members != 1 {
+		t.Errorf("Members: got %v, expected 1", s.Members)
+	}
+	if s.PacketsSent == 0 {
+		t.Error("PacketsSent: got 0, expected nonzero")
+	}
+	if s.PacketsReceived < 2 {
+		t.Errorf("PacketsReceived: got %v, expected at least 2", s.PacketsReceived)
+	}
+	if s.PacketsDropped != 1 {
+		t.Errorf("PacketsDropped: got %v, expected 1", s.PacketsDropped)
+	}
+	if s.Uptime <= 0 {
+		t.Errorf("Uptime: got %v, expected positive", s.Uptime)
+	}
+}
+
+func TestReceiveBufferSize(t *testing.T) {
+	if _, err := Start("", WithReceiveBufferSize(100)); err == nil {
+		t.Error("Start with receive buffer size 100: got nil error")
+	}
+	n, err := Start("", WithReceiveBufferSize(minReceiveBufferSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(n.localAddrPort()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(make([]byte, minReceiveBufferSize+1)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if s := n.Stats(); s.PacketsReceived != 1 || s.PacketsDropped != 1 {
+		t.Errorf("got %v packets received, %v dropped; expected 1, 1", s.PacketsReceived, s.PacketsDropped)
+	}
+}
+
+func TestSocketBufferSizes(t *testing.T) {
+	for _, opt := range []Option{WithReadBufferSize(-1), WithWriteBufferSize(-1)} {
+		if _, err := Start("", opt); err == nil {
+			t.Error("Start with negative socket buffer size: got nil error")
+		}
+	}
+	n, err := Start("", WithReadBufferSize(1<<20), WithWriteBufferSize(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Shutdown()
+}
+
+func TestMaxPacketSize(t *testing.T) {
+	const size = 600
+	n, err := Start("", WithMaxPacketSize(size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	errs := make(chan error, 1)
+	n.OnError(func(err error) { errs <- err })
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var msgs []*message
+	for i := 0; i < 10; i++ {
+		msgs = append(msgs, &message{Type: alive, NodeID: randID()})
+	}
+	if err := n.writeTo(packet{Type: ping, Msgs: msgs}, conn.LocalAddr().(*net.UDPAddr).AddrPort()); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, maxReceiveBufferSize)
+	nb, _, err := conn.ReadFromUDPAddrPort(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nb > size {
+		t.Errorf("received %v bytes; expected at most %v", nb, size)
+	}
+	<-errs
+	if s := n.Stats(); s.OversizedDropped == 0 {
+		t.Error("OversizedDropped: got 0, expected nonzero")
+	}
+}
+
+func TestSuspicionTimeoutAccessor(t *testing.T) {
+	n, err := Start("", WithSuspicionJitter(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	if got := n.SuspicionTimeout(); got != 1 {
+		t.Errorf("no members: got %v, expected 1", got)
+	}
+	n.receive(packet{
+		Type:     ping,
+		remoteID: "AAA",
+		Msgs: []*message{
+			{Type: alive, NodeID: "AAA"},
+			{Type: alive, NodeID: "BBB"},
+			{Type: alive, NodeID: "CCC"},
+		},
+	})
+	// ⌈2 ln 4⌉ = 3
+	if got := n.SuspicionTimeout(); got != 3 {
+		t.Errorf("three members: got %v, expected 3", got)
+	}
+}
+
+func TestQueueCounts(t *testing.T) {
+	n, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	n.receive(packet{
+		Type:     ping,
+		remoteID: "AAA",
+		Msgs:     []*message{{Type: alive, NodeID: "AAA"}},
+	})
+	n.receive(packet{
+		Type:     ping,
+		remoteID: "AAA",
+		Msgs:     []*message{{Type: alive, NodeID: "AAA", Incarnation: 1}},
+	})
+	if s := n.Stats(); s.MsgsSuperseded != 1 {
+		t.Errorf("MsgsSuperseded: got %v, expected 1", s.MsgsSuperseded)
+	}
+	n.mu.Lock()
+	for n.fsm.msgQueue.Len() > 0 {
+		n.fsm.msgQueue.Pop()
+	}
+	n.mu.Unlock()
+	if s := n.Stats(); s.MsgsRetired != 1 {
+		t.Errorf("MsgsRetired: got %v, expected 1", s.MsgsRetired)
+	}
+}
+
+// A fullConn is a flakyConn whose writes fail as if its send buffer were full
+// until full writes have been attempted.
+type fullConn struct {
+	flakyConn
+	mu   sync.Mutex
+	full int
+}
+
+func (c *fullConn) WriteToUDPAddrPort(b []byte, addr netip.AddrPort) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.full > 0 {
+		c.full--
+		return 0, &net.OpError{Op: "write", Net: "udp", Err: syscall.ENOBUFS}
+	}
+	return len(b), nil
+}
+
+func TestSendBufferFull(t *testing.T) {
+	conn := &fullConn{flakyConn: flakyConn{errs: make(chan error)}}
+	n := start(conn, defaultConfig())
+	defer n.Shutdown()
+	addr := netip.MustParseAddrPort("127.0.0.1:7946")
+
+	// A brief condition is retried
+	conn.mu.Lock()
+	conn.full = sendRetries
+	conn.mu.Unlock()
+	if err := n.writeTo(packet{Type: ping}, addr); err != nil {
+		t.Fatal(err)
+	}
+	if s := n.Stats(); s.PacketsSent != 1 || s.SendsDropped != 0 {
+		t.Errorf("got %v packets sent, %v dropped; expected 1, 0", s.PacketsSent, s.SendsDropped)
+	}
+
+	// A persistent one drops the packet
+	conn.mu.Lock()
+	conn.full = sendRetries + 1
+	conn.mu.Unlock()
+	if err := n.writeTo(packet{Type: ping}, addr); err != nil {
+		t.Fatal(err)
+	}
+	if s := n.Stats(); s.PacketsSent != 1 || s.SendsDropped != 1 {
+		t.Errorf("got %v packets sent, %v dropped; expected 1, 1", s.PacketsSent, s.SendsDropped)
+	}
+}
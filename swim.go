@@ -1,132 +1,493 @@
 package swim
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"math/rand"
 	"net"
 	"net/netip"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 const (
 	tickAverage = time.Second
 	pingTimeout = 200 * time.Millisecond
+
+	// minTickPeriod and maxTickPeriod are the lengths of the shortest and
+	// longest protocol periods.
+	minTickPeriod = tickAverage * 9 / 10
+	maxTickPeriod = tickAverage * 11 / 10
+
+	// minFlushInterval is the minimum time between flushes.
+	minFlushInterval = tickAverage / 10
+
+	// minJoinInterval is the minimum time between join requests to the same
+	// address.
+	minJoinInterval = pingTimeout
+
+	// maxMemoLen is the maximum length of a memo body, chosen to ensure
+	// transmission within a single UDP packet.
+	maxMemoLen = 500
+
+	// maxMemoIDLen is the maximum length of a memo ID chosen by the
+	// application.
+	maxMemoIDLen = 64
+
+	// minParallelSend is the smallest number of packets that a Node sends
+	// concurrently rather than one at a time.
+	minParallelSend = 8
+
+	// sendWorkers is the maximum number of packets a Node writes at once.
+	sendWorkers = 4
+
+	// sendRetries is the number of times a Node retries a write that fails
+	// because the socket's send buffer is full, waiting sendRetryDelay
+	// before each retry, before dropping the packet.
+	sendRetries    = 2
+	sendRetryDelay = time.Millisecond
+
+	// maxReadBackoff is the longest a Node waits to read again after
+	// successive transient read errors.
+	maxReadBackoff = 100 * time.Millisecond
+)
+
+var (
+	// ErrMemoTooLong is returned by PostMemo when a memo exceeds the length
+	// limit.
+	ErrMemoTooLong = errors.New("memo too long")
+
+	// ErrInvalidMemoID is returned by PostMemoWithID when the memo ID is
+	// empty, too long, or contains characters other than printable ASCII.
+	ErrInvalidMemoID = errors.New("invalid memo ID")
+
+	// ErrNotMember is returned when an operation names a peer that is not a
+	// member of the network.
+	ErrNotMember = errors.New("not a member")
+
+	// ErrNodeClosed is returned when an operation is attempted on a Node
+	// that has stopped participating in the network.
+	ErrNodeClosed = errors.New("node closed")
+
+	// ErrJoinTimeout is returned by JoinAndWait when the remote node does not
+	// respond before the context expires.
+	ErrJoinTimeout = errors.New("join timed out")
+
+	// ErrObserver is returned when an operation that requires membership is
+	// attempted on an observer.
+	ErrObserver = errors.New("node is an observer")
+
+	// ErrDraining is returned when an operation that would give a Node new
+	// work is attempted after Drain has been called.
+	ErrDraining = errors.New("node is draining")
 )
 
 // A Node is a network node participating in the SWIM protocol.
 type Node struct {
-	mu         sync.Mutex // protects the following fields
-	fsm        *stateMachine
-	handleJoin func(id string, addr netip.AddrPort)
-	handleMemo func(id string, addr netip.AddrPort, memo []byte)
-	handleFail func(id string)
+	mu             sync.Mutex // protects the following fields
+	fsm            *stateMachine
+	updateHandlers []*func(u Update)
+	errHandlers    []*func(err error)
+	heardHandlers  []*func(nodeID string)
+	heardPending   map[id]bool // members with a heard handler call queued
+	stableHandlers []*stableHandler
+	stableGen      uint64    // generation when stability was last checked
+	stableSince    time.Time // when the membership was last seen to change
+	closed         bool      // whether n has stopped participating in the network
+	lastFlush      time.Time
+	lastTick       time.Time                        // when n last began a protocol period
+	lastJoins      map[netip.AddrPort]time.Time     // recent join requests
+	joinWaits      map[chan struct{}]netip.AddrPort // seeds awaited by JoinAndWait
+	counters       counters
 
 	id       id // copy of fsm.id
-	conn     *net.UDPConn
-	stopTick chan struct{}
+	cluster  string
+	started  time.Time
+	bufSize  int           // size of the receive buffer
+	maxSize  int           // maximum size of a sent packet
+	indirect time.Duration // time to wait for indirect acks, or 0 for the rest of the period
+	compress bool
+	conn     Transport
+
+	advertise func(peer netip.AddrPort) netip.AddrPort // n's address for a peer, if set
+	tracer    func(dir Direction, addr netip.AddrPort, p TracePacket)
+	stopTick  chan struct{}
+	kick      chan struct{} // starts a new protocol period early
+	handlers  *handlerQueue // pending handler calls, if concurrency is limited
+}
+
+// A FailReason describes why a peer left the network. It distinguishes
+// planned departures, which typically need no attention, from crashes and
+// network failures, which may warrant an alert. New reasons may be added in
+// the future, so handlers should treat unrecognized values like Failed.
+type FailReason byte
+
+const (
+	// Failed indicates that the peer stopped responding: it did not refute
+	// suspicion of its failure within the suspicion timeout, or, if
+	// suspicion is disabled, did not acknowledge a probe.
+	Failed FailReason = iota
+
+	// Left indicates that the peer announced its departure from the network
+	// by calling Drain.
+	Left
+)
+
+func (r FailReason) String() string {
+	switch r {
+	case Failed:
+		return "failed"
+	case Left:
+		return "left"
+	}
+	return fmt.Sprintf("FailReason(%d)", byte(r))
 }
 
-// Start creates a new Node listening on the local UDP address.
+// Start creates a new Node listening on the local UDP address, which has the
+// form "host:port".
 //
 // If the address's host is empty or a literal unspecified IP address, the
 // Node listens on all available IP addresses of the local system except
 // multicast IP addresses. If the port is empty or "0", as in "127.0.0.1:"
-// or "[::1]:0", a port number is automatically chosen.
-func Start(address string) (*Node, error) {
-	addr, err := net.ResolveUDPAddr("udp", address)
+// or "[::1]:0", a port number is automatically chosen. The empty address is
+// equivalent to ":". IPv6 hosts must be enclosed in brackets.
+//
+// If the address is malformed, Start returns an error wrapping
+// ErrInvalidAddress that describes the problem.
+func Start(address string, opts ...Option) (*Node, error) {
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.conn != nil {
+		return startConn(cfg)
+	}
+	addr, err := resolveAddr(address)
 	if err != nil {
 		return nil, err
 	}
+	return listen(addr, cfg)
+}
+
+// StartAddrPort is like Start, but takes the local address as a
+// netip.AddrPort, which requires no parsing or resolution.
+func StartAddrPort(addr netip.AddrPort, opts ...Option) (*Node, error) {
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.conn != nil {
+		return startConn(cfg)
+	}
+	if !addr.Addr().IsValid() {
+		return nil, fmt.Errorf("%w %v: invalid host", ErrInvalidAddress, addr)
+	}
+	return listen(net.UDPAddrFromAddrPort(addr), cfg)
+}
+
+// listen creates a new Node listening on addr.
+func listen(addr *net.UDPAddr, cfg config) (*Node, error) {
 	conn, err := net.ListenUDP("udp", addr)
 	if err != nil {
 		return nil, err
 	}
-	n := &Node{
-		handleJoin: func(string, netip.AddrPort) {},
-		handleMemo: func(string, netip.AddrPort, []byte) {},
-		handleFail: func(string) {},
+	if err := setBuffers(conn, cfg); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return start(conn, cfg), nil
+}
 
-		conn:     conn,
-		stopTick: make(chan struct{}),
+// startConn creates a new Node that communicates through the socket set by
+// WithConn. The caller must have verified that the socket implements
+// Transport.
+func startConn(cfg config) (*Node, error) {
+	if err := setBuffers(cfg.conn, cfg); err != nil {
+		return nil, err
 	}
+	return start(cfg.conn.(Transport), cfg), nil
+}
 
-	wgs := make(map[id]*struct{ join, memo sync.WaitGroup })
+// setBuffers sets the sizes of conn's operating system buffers as cfg
+// specifies, if conn supports setting them.
+func setBuffers(conn net.PacketConn, cfg config) error {
+	if c, ok := conn.(interface{ SetReadBuffer(int) error }); ok && cfg.readBuffer > 0 {
+		if err := c.SetReadBuffer(cfg.readBuffer); err != nil {
+			return fmt.Errorf("set read buffer: %w", err)
+		}
+	}
+	if c, ok := conn.(interface{ SetWriteBuffer(int) error }); ok && cfg.writeBuffer > 0 {
+		if err := c.SetWriteBuffer(cfg.writeBuffer); err != nil {
+			return fmt.Errorf("set write buffer: %w", err)
+		}
+	}
+	return nil
+}
+
+// start creates a new Node that communicates through conn.
+func start(conn Transport, cfg config) *Node {
+	n := &Node{
+		cluster:     cfg.clusterName,
+		lastJoins:   make(map[netip.AddrPort]time.Time),
+		started:     time.Now(),
+		stableSince: time.Now(),
+		bufSize:     cfg.receiveBufferSize,
+		maxSize:     cfg.maxPacketSize,
+		indirect:    cfg.indirectTimeout,
+		compress:    cfg.compression,
+		advertise:   cfg.advertise,
+		tracer:      cfg.tracer,
+		conn:        conn,
+		stopTick:    make(chan struct{}),
+		kick:        make(chan struct{}, 1),
+	}
+	n.heardPending = make(map[id]bool)
+	n.joinWaits = make(map[chan struct{}]netip.AddrPort)
+
+	d := newOrderedDispatcher(n.dispatch)
 	n.fsm = newStateMachine(
 		func(id id, addr netip.AddrPort) {
-			wg := &struct{ join, memo sync.WaitGroup }{}
-			wgs[id] = wg
-			wg.join.Add(1)
-			go func() {
-				defer wg.join.Done()
-				n.handleJoin(string(id), addr)
-			}()
+			if len(n.fsm.members) == 1 {
+				// Begin probing the first member without waiting for
+				// the next protocol period
+				select {
+				case n.kick <- struct{}{}:
+				default:
+				}
+			}
+			d.join(id, n.reportUpdate(n.update(JoinUpdate, id, addr)))
 		},
 		func(id id, addr netip.AddrPort, memo []byte) {
-			wg := wgs[id]
-			wg.memo.Add(1)
-			go func() {
-				defer wg.memo.Done()
-				wg.join.Wait()
-				n.handleMemo(string(id), addr, memo)
-			}()
+			u := n.update(MemoUpdate, id, addr)
+			u.Memo = memo
+			d.memo(id, n.reportUpdate(u))
 		},
-		func(id id) {
-			wg := wgs[id]
-			delete(wgs, id)
-			go func() {
-				wg.memo.Wait()
-				n.handleFail(string(id))
-			}()
+		func(id id, reason FailReason) {
+			var addr netip.AddrPort
+			if p, ok := n.fsm.members[id]; ok {
+				addr = p.addr
+			}
+			u := n.update(FailUpdate, id, addr)
+			u.Reason = reason
+			d.fail(id, n.reportUpdate(u))
 		},
 	)
+	if cfg.idBytes != defaultIDBytes {
+		n.fsm.id = randIDLen(cfg.idBytes)
+	}
+	seed := n.fsm.id.seed()
+	if cfg.probeSeed != nil {
+		seed = *cfg.probeSeed
+	}
+	n.fsm.order.SetRand(rand.New(rand.NewSource(seed)))
+	n.fsm.suspicionScale = 1 + cfg.suspicionJitter*(2*rand.Float64()-1)
+	n.fsm.detector = cfg.detector
+	n.fsm.hysteresis = cfg.hysteresis
+	n.fsm.smallSize = cfg.smallSize
+	n.fsm.smallMisses = cfg.smallMisses
+	n.fsm.suspicion = cfg.suspicion
+	n.fsm.suspectMemos = cfg.suspectMemos
+	n.fsm.selfMemos = cfg.selfMemos
+	n.fsm.gossipFanout = cfg.gossipFanout
+	n.fsm.probesPerPeriod = cfg.probesPerPeriod
+	n.fsm.retransmitMult = cfg.retransmitMult
+	n.fsm.maxIdle = cfg.maxIdle
+	n.fsm.memoBudget = cfg.memoBudget
+	n.fsm.observer = cfg.observer
+	n.fsm.maxMembers = cfg.maxMembers
+	n.fsm.quarantine = cfg.quarantine
+	if cfg.removedCapacity > 0 {
+		n.fsm.removed = newRemovedFilter(cfg.removedCapacity, cfg.removedFPRate)
+	}
+	n.fsm.seeds = cfg.rejoinSeeds
+	n.fsm.rejoinGrace = cfg.rejoinGrace
+	n.fsm.newestFirst = cfg.memoPriority == NewestFirst
+	if cfg.knownOnly {
+		n.fsm.trusted = make(map[netip.AddrPort]bool)
+		for _, addr := range cfg.trustedAddrs {
+			n.fsm.trust(addr)
+		}
+	}
+	if f := cfg.joinFilter; f != nil {
+		n.fsm.joinFilter = func(id id, addr netip.AddrPort) bool { return f(string(id), addr) }
+	}
 	n.id = n.fsm.id
+	if cfg.handlerConcurrency > 0 {
+		n.handlers = newHandlerQueue()
+		for i := 0; i < cfg.handlerConcurrency; i++ {
+			go n.runHandlers()
+		}
+	}
 	go n.runReceive()
 	go n.runTick()
-	return n, nil
+	return n
 }
 
-// OnJoin uses f as n's join handler, to be called when a peer joins the
-// network.
-func (n *Node) OnJoin(f func(nodeID string, addr netip.AddrPort)) {
-	n.mu.Lock()
-	defer n.mu.Unlock()
-	n.handleJoin = f
+// OnJoin registers f as a join handler, to be called when a peer joins the
+// network. Handlers are called in the order in which they were registered.
+// OnJoin returns a function that unregisters f. It is a convenience wrapper
+// around OnUpdate.
+func (n *Node) OnJoin(f func(nodeID string, addr netip.AddrPort)) (unregister func()) {
+	return n.OnUpdate(func(u Update) {
+		if u.Kind == JoinUpdate {
+			f(u.NodeID, u.Addr)
+		}
+	})
 }
 
-// OnMemo uses f as n's memo handler, to be called when n receives a memo.
-// For each peer, calls to f happen after the join handler (if any) returns.
-func (n *Node) OnMemo(f func(nodeID string, addr netip.AddrPort, memo []byte)) {
-	n.mu.Lock()
-	defer n.mu.Unlock()
-	n.handleMemo = f
+// OnMemo registers f as a memo handler, to be called when n receives a memo.
+// For each peer, calls to f happen after the join handlers (if any) return.
+// OnMemo returns a function that unregisters f. It is a convenience wrapper
+// around OnUpdate.
+func (n *Node) OnMemo(f func(nodeID string, addr netip.AddrPort, memo []byte)) (unregister func()) {
+	return n.OnUpdate(func(u Update) {
+		if u.Kind == MemoUpdate {
+			f(u.NodeID, u.Addr, u.Memo)
+		}
+	})
+}
+
+// OnFail registers f as a failure handler, to be called when a peer leaves
+// the network. For each peer, calls to f happen after all calls to the memo
+// handlers (if any) return. OnFail returns a function that unregisters f.
+func (n *Node) OnFail(f func(nodeID string)) (unregister func()) {
+	return n.OnFailReason(func(nodeID string, _ FailReason) { f(nodeID) })
 }
 
-// OnFail uses f as n's failure handler, to be called when a peer leaves the
-// network. For each peer, the call to f happens after all calls to the memo
-// handler (if any) return.
-func (n *Node) OnFail(f func(nodeID string)) {
+// OnFailReason is like OnFail, but f also receives the reason the peer left.
+func (n *Node) OnFailReason(f func(nodeID string, reason FailReason)) (unregister func()) {
+	return n.OnUpdate(func(u Update) {
+		if u.Kind == FailUpdate {
+			f(u.NodeID, u.Reason)
+		}
+	})
+}
+
+// OnError registers f as an error handler, to be called when n encounters a
+// problem that does not stop it from participating in the network, such as
+// having to omit messages from an oversized packet. OnError returns a
+// function that unregisters f.
+func (n *Node) OnError(f func(err error)) (unregister func()) {
+	return addHandler(&n.mu, &n.errHandlers, f)
+}
+
+// OnHeard registers f as a handler to be called when n receives a packet of
+// any kind from a member. It reports the freshest evidence that a peer is
+// alive, for applications that track when each peer was last heard from
+// without polling LastSeen. Packets from peers that are not yet members are
+// not reported. Heard handlers are called like any other handler, and so
+// usually after n has processed the packet. Packets that arrive from a peer
+// before the handlers have been called for an earlier one are not reported
+// separately, so each peer has at most one call pending at a time. OnHeard
+// returns a function that unregisters f.
+func (n *Node) OnHeard(f func(nodeID string)) (unregister func()) {
+	return addHandler(&n.mu, &n.heardHandlers, f)
+}
+
+// reportError passes err to n's error handlers.
+func (n *Node) reportError(err error) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
-	n.handleFail = f
+	if n.closed || len(n.errHandlers) == 0 {
+		return
+	}
+	hs := n.errHandlers
+	n.dispatch(func() {
+		for _, h := range hs {
+			(*h)(err)
+		}
+	})
+}
+
+// addHandler appends f to the handlers in *hs, which are protected by mu, and
+// returns a function that removes it. Removal does not modify the array
+// underlying *hs, so copies of *hs taken while holding mu remain valid.
+func addHandler[F any](mu *sync.Mutex, hs *[]*F, f F) (remove func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	h := &f
+	*hs = append(*hs, h)
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for i := range *hs {
+			if (*hs)[i] == h {
+				*hs = append((*hs)[:i:i], (*hs)[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// dispatch arranges for f to be called: in a new goroutine by default, or by
+// one of n's handler goroutines, after all previously dispatched calls have
+// started, if n's handler concurrency is limited.
+func (n *Node) dispatch(f func()) {
+	if n.handlers == nil {
+		go f()
+		return
+	}
+	n.handlers.push(f)
+}
+
+// runHandlers calls the functions popped from n.handlers in turn.
+func (n *Node) runHandlers() {
+	for {
+		f, ok := n.handlers.pop()
+		if !ok {
+			return
+		}
+		f()
+	}
 }
 
 func (n *Node) runTick() {
 	periodTimer := time.NewTimer(0)
 	pingTimer := stoppedTimer()
+	indirectTimer := stoppedTimer()
+	stableTimer := stoppedTimer()
+	checkStable := func() {
+		stopTimer(stableTimer)
+		if d := n.checkStable(); d > 0 {
+			stableTimer.Reset(d)
+		}
+	}
+	startPeriod := func() {
+		// Choose a random tick period within 10% of tickAverage to
+		// desynchronize the nodes' periods
+		tickPeriod := minTickPeriod + time.Duration(float64(maxTickPeriod-minTickPeriod)*rand.Float64())
+		periodTimer.Reset(tickPeriod)
+		pingTimer.Reset(pingTimeout)
+		stopTimer(indirectTimer)
+		n.send(n.tick())
+		checkStable()
+	}
 	for {
 		select {
 		case <-periodTimer.C:
-			// Choose a random tick period within 10% of tickAverage to
-			// desynchronize the nodes' periods
-			tickPeriod := time.Duration(float64(tickAverage) * (0.9 + 0.2*rand.Float64()))
-			periodTimer.Reset(tickPeriod)
-			pingTimer.Reset(pingTimeout)
-			n.send(n.tick())
+			startPeriod()
+		case <-n.kick:
+			stopTimer(periodTimer)
+			startPeriod()
 		case <-pingTimer.C:
+			if n.indirect > 0 {
+				indirectTimer.Reset(n.indirect)
+			}
 			n.send(n.timeout())
+		case <-indirectTimer.C:
+			n.send(n.expire())
+		case <-stableTimer.C:
+			checkStable()
 		case <-n.stopTick:
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if n.handlers != nil {
+				n.handlers.close()
+			}
 			return
 		}
 	}
@@ -135,86 +496,643 @@ func (n *Node) runTick() {
 func (n *Node) tick() []packet {
 	n.mu.Lock()
 	defer n.mu.Unlock()
-	return n.fsm.tick()
+	if n.closed {
+		return nil
+	}
+	ps := n.fsm.tick()
+	n.lastTick = time.Now()
+	return ps
+}
+
+// LastTick returns the time at which n last began a protocol period, or the
+// zero Time if it has yet to begin one. A Node begins a period about once per
+// second, so a LastTick much older than that indicates that n's protocol loop
+// is stalled, perhaps waiting for a slow handler, and that its peers are
+// likely to suspect it of having failed. Stats reports the same information
+// as SinceTick.
+func (n *Node) LastTick() time.Time {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.lastTick
+}
+
+func (n *Node) expire() []packet {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return nil
+	}
+	return n.fsm.expire()
 }
 
 func (n *Node) timeout() []packet {
 	n.mu.Lock()
 	defer n.mu.Unlock()
+	if n.closed {
+		return nil
+	}
 	return n.fsm.timeout()
 }
 
 // Join connects n to a remote node. This is typically used to connect a new
 // node to an existing network.
+//
+// Join is safe to call repeatedly, as in a retry loop. To avoid flooding the
+// remote node, Join does nothing if it sent a request to the same address
+// less than 200 milliseconds ago.
 func (n *Node) Join(remote netip.AddrPort) error {
 	n.mu.Lock()
-	p := packet{
-		Type: ping,
-		Msgs: []*message{n.fsm.aliveMessage()},
+	if n.closed {
+		n.mu.Unlock()
+		return ErrNodeClosed
+	}
+	now := time.Now()
+	for addr, t := range n.lastJoins {
+		if now.Sub(t) >= minJoinInterval {
+			delete(n.lastJoins, addr)
+		}
+	}
+	if _, ok := n.lastJoins[remote]; ok {
+		n.mu.Unlock()
+		return nil
 	}
+	n.lastJoins[remote] = now
+	n.fsm.trust(remote)
+	p := n.fsm.makeJoin(remote)
 	n.mu.Unlock()
-	return n.writeTo(p, remote)
+	if err := n.writeTo(p, remote); err != nil {
+		return fmt.Errorf("join %v: %w", remote, err)
+	}
+	return nil
 }
 
+// JoinAndWait is like Join, but blocks until n receives a reply from the
+// remote node, resending the join request once per protocol period. A reply
+// is a packet from remote after which n has the sender as a member, or which
+// brings news of a member n did not know, so JoinAndWait succeeds even if
+// the remote node advertises an address other than remote. If ctx expires
+// first, JoinAndWait returns an error wrapping ErrJoinTimeout.
+func (n *Node) JoinAndWait(ctx context.Context, remote netip.AddrPort) error {
+	replied := make(chan struct{}, 1)
+	n.mu.Lock()
+	n.joinWaits[replied] = remote
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		delete(n.joinWaits, replied)
+		n.mu.Unlock()
+	}()
+	t := time.NewTicker(tickAverage)
+	defer t.Stop()
+	for {
+		if err := n.Join(remote); err != nil {
+			return err
+		}
+		select {
+		case <-replied:
+			return nil
+		case <-t.C:
+		case <-ctx.Done():
+			return fmt.Errorf("join %v: %w", remote, ErrJoinTimeout)
+		}
+	}
+}
+
+// RemoveMember removes the peer with the given ID from n's network as failed
+// without waiting for n to detect its failure, which is useful when the peer
+// is known to have stopped, for example because a scheduler terminated it.
+// n calls its fail handlers and disseminates news of the failure to the rest
+// of the network, unless n is an observer. RemoveMember returns an error
+// wrapping ErrNotMember if the peer is not a member.
+//
+// If the peer is in fact still running, n ignores it for the quarantine
+// period set by WithQuarantine, after which the peer can rejoin by contacting
+// n directly. A peer that receives news of its own removal, however, stops
+// participating in the network, as it would if it had been declared failed
+// mistakenly.
+func (n *Node) RemoveMember(nodeID string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return ErrNodeClosed
+	}
+	if !n.fsm.evict(id(nodeID)) {
+		return fmt.Errorf("remove %v: %w", nodeID, ErrNotMember)
+	}
+	return nil
+}
+
+// sameAddr reports whether a and b are the same address, treating IPv4
+// addresses and their IPv4-mapped IPv6 equivalents as equal.
+func sameAddr(a, b netip.AddrPort) bool {
+	return a.Addr().Unmap() == b.Addr().Unmap() && a.Port() == b.Port()
+}
+
+// send writes each packet in ps to its remote address. Large batches, such as
+// those produced by gossip fanout, are written concurrently, so that a write
+// that blocks does not delay the others. Errors writing individual packets are
+// reported to n's error handlers, and once n is closed, the remaining packets
+// are discarded.
 func (n *Node) send(ps []packet) {
+	if len(ps) < minParallelSend {
+		n.sendEach(ps)
+		return
+	}
+	n.sendParallel(ps)
+}
+
+// sendEach writes the packets in ps one at a time, in order.
+func (n *Node) sendEach(ps []packet) {
 	for _, p := range ps {
-		if err := n.writeTo(p, p.remoteAddr); err != nil {
+		if !n.sendOne(p) {
 			return
 		}
 	}
 }
 
-// writeTo writes p to addr.
+// sendParallel writes the packets in ps using up to sendWorkers goroutines,
+// and returns once all have been written or discarded.
+func (n *Node) sendParallel(ps []packet) {
+	ch := make(chan packet)
+	var wg sync.WaitGroup
+	var closed int32 // set once n is closed
+	for i := 0; i < sendWorkers && i < len(ps); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range ch {
+				if atomic.LoadInt32(&closed) == 0 && !n.sendOne(p) {
+					atomic.StoreInt32(&closed, 1)
+				}
+			}
+		}()
+	}
+	for _, p := range ps {
+		ch <- p
+	}
+	close(ch)
+	wg.Wait()
+}
+
+// sendOne writes p to its remote address, reporting any error other than n
+// being closed to n's error handlers, and reports whether n can continue
+// sending.
+func (n *Node) sendOne(p packet) bool {
+	if !p.remoteAddr.IsValid() {
+		// Addressed to a member whose address is unknown
+		return true
+	}
+	err := n.writeTo(p, p.remoteAddr)
+	if errors.Is(err, ErrNodeClosed) {
+		return false
+	}
+	if err != nil {
+		n.reportError(fmt.Errorf("send to %v: %w", p.remoteAddr, err))
+	}
+	return true
+}
+
+// writeTo writes p to addr. If p would exceed n's maximum packet size, writeTo
+// omits messages from the end of p.Msgs, which are the least important.
 func (n *Node) writeTo(p packet, addr netip.AddrPort) error {
-	b, err := json.Marshal(envelope{n.id, p})
+	p.Msgs = n.advertiseTo(p.Msgs, addr)
+	b := n.encode(p)
+	var omitted int
+	for len(b) > n.maxSize && len(p.Msgs) > 0 {
+		p.Msgs = p.Msgs[:len(p.Msgs)-1]
+		omitted++
+		b = n.encode(p)
+	}
+	if omitted > 0 {
+		n.mu.Lock()
+		n.counters.oversized += uint64(omitted)
+		n.mu.Unlock()
+		n.reportError(fmt.Errorf("packet to %v exceeds %v bytes: omitted %v messages", addr, n.maxSize, omitted))
+	}
+	n.trace(Outbound, addr, p)
+	_, err := n.conn.WriteToUDPAddrPort(b, addr)
+	for i := 0; i < sendRetries && isBufferFull(err); i++ {
+		time.Sleep(sendRetryDelay)
+		_, err = n.conn.WriteToUDPAddrPort(b, addr)
+	}
+	switch {
+	case errors.Is(err, net.ErrClosed):
+		return fmt.Errorf("%w: %v", ErrNodeClosed, err)
+	case isBufferFull(err):
+		// Drop the packet as a congested network would; the protocol
+		// tolerates packet loss
+		n.count(&n.counters.sendDropped)
+		return nil
+	case err != nil:
+		return err
+	}
+	n.count(&n.counters.sent)
+	return nil
+}
+
+// isBufferFull reports whether err, returned by a write, indicates that the
+// socket's send buffer is temporarily full.
+func isBufferFull(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EWOULDBLOCK) ||
+		errors.Is(err, syscall.ENOBUFS)
+}
+
+// advertiseTo returns msgs, with a copy of any alive message about n that
+// carries the address n's advertise function returns for the peer at addr in
+// place of the original. The messages themselves are not modified, because
+// the same message may be sent to many peers.
+func (n *Node) advertiseTo(msgs []*message, addr netip.AddrPort) []*message {
+	if n.advertise == nil {
+		return msgs
+	}
+	self := n.advertise(addr)
+	if self == (netip.AddrPort{}) {
+		return msgs
+	}
+	out := make([]*message, len(msgs))
+	for i, m := range msgs {
+		out[i] = m
+		if m.NodeID == n.id && m.Type == alive {
+			c := *m
+			c.Addr = self
+			out[i] = &c
+		}
+	}
+	return out
+}
+
+// encode returns the wire representation of p, compressed if n uses
+// compression.
+func (n *Node) encode(p packet) []byte {
+	b, err := json.Marshal(envelope{SrcID: n.id, Cluster: n.cluster, P: p})
 	if err != nil {
 		panic(err)
 	}
-	_, err = n.conn.WriteToUDPAddrPort(b, addr)
-	return err
+	if n.compress {
+		return compress(b)
+	}
+	return b
 }
 
 func (n *Node) runReceive() {
 	defer close(n.stopTick)
+	defer func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		n.closed = true
+	}()
+	readLoop(n.conn, n.bufSize, func(err error) {
+		n.count(&n.counters.readErrors)
+		n.reportError(fmt.Errorf("read: %w", err))
+	}, func(b []byte, addr netip.AddrPort) bool {
+		if b == nil {
+			n.count(&n.counters.received)
+			n.count(&n.counters.dropped)
+			return true
+		}
+		return n.handleDatagram(b, addr)
+	})
+}
+
+// readLoop reads datagrams from conn into a buffer of the given size and
+// passes each to handle until conn fails permanently or handle returns false.
+// A datagram that fills the buffer may have been truncated, so handle receives
+// nil in its place. Transient read errors are passed to readErr, if it is not
+// nil, and followed by a growing backoff in case the condition persists.
+func readLoop(conn Transport, size int, readErr func(error), handle func([]byte, netip.AddrPort) bool) {
+	b := make([]byte, size)
+	var backoff time.Duration
 	for {
-		b := make([]byte, 1<<16)
-		len, addr, err := n.conn.ReadFromUDPAddrPort(b)
+		len, addr, err := conn.ReadFromUDPAddrPort(b)
 		if err != nil {
-			return
-		}
-		var e envelope
-		if err := json.Unmarshal(b[:len], &e); err != nil {
+			if !isTransient(err) {
+				return
+			}
+			if readErr != nil {
+				readErr(err)
+			}
+			backoff = nextReadBackoff(backoff)
+			time.Sleep(backoff)
 			continue
 		}
-		e.P.remoteID = e.SrcID
-		e.P.remoteAddr = addr
-		ps, ok := n.receive(e.P)
-		if !ok {
+		backoff = 0
+		d := b[:len]
+		if len == cap(b) {
+			// Possibly truncated
+			d = nil
+		}
+		if !handle(d, addr) {
 			return
 		}
-		n.send(ps)
 	}
 }
 
+// handleDatagram processes a datagram received from addr and reports whether
+// n can continue participating in the protocol. Datagrams from invalid source
+// addresses, which would corrupt n's record of its peers' addresses, are
+// discarded, as are those that are malformed or labeled with another cluster
+// name. Compressed datagrams are accepted whether or not n uses compression.
+func (n *Node) handleDatagram(b []byte, addr netip.AddrPort) bool {
+	n.count(&n.counters.received)
+	if !isValidSource(addr) {
+		n.count(&n.counters.rejected)
+		return true
+	}
+	b, err := decompress(b)
+	if err != nil {
+		n.count(&n.counters.dropped)
+		return true
+	}
+	var e envelope
+	if err := json.Unmarshal(b, &e); err != nil || e.Cluster != n.cluster {
+		n.count(&n.counters.dropped)
+		return true
+	}
+	e.P.remoteID = e.SrcID
+	e.P.remoteAddr = addr
+	n.trace(Inbound, addr, e.P)
+	ps, ok := n.receive(e.P)
+	if !ok {
+		return false
+	}
+	n.send(ps)
+	return true
+}
+
+// isTransient reports whether err, returned by a read, is a temporary
+// condition after which the connection remains usable, rather than a sign that
+// it has been closed or has otherwise failed permanently.
+func isTransient(err error) bool {
+	if errors.Is(err, net.ErrClosed) {
+		return false
+	}
+	if errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.ENOBUFS) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		// ICMP errors from earlier writes can surface as read errors
+		// on some platforms
+		return true
+	}
+	var te interface{ Temporary() bool }
+	return errors.As(err, &te) && te.Temporary()
+}
+
+// nextReadBackoff returns the time to wait after a transient read error,
+// given the previous wait, or 0 if the previous read succeeded.
+func nextReadBackoff(d time.Duration) time.Duration {
+	if d = 2*d + time.Millisecond; d > maxReadBackoff {
+		d = maxReadBackoff
+	}
+	return d
+}
+
+// isValidSource reports whether addr is a valid unicast source address.
+func isValidSource(addr netip.AddrPort) bool {
+	a := addr.Addr().Unmap()
+	return a.IsValid() && !a.IsUnspecified() && !a.IsMulticast() && addr.Port() != 0
+}
+
 func (n *Node) receive(p packet) ([]packet, bool) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
-	return n.fsm.receive(p)
+	if n.closed {
+		return nil, false
+	}
+	if len(n.heardHandlers) > 0 && n.fsm.isMember(p.remoteID) && !n.heardPending[p.remoteID] {
+		n.heardPending[p.remoteID] = true
+		hs, id := n.heardHandlers, p.remoteID
+		n.dispatch(func() {
+			n.mu.Lock()
+			delete(n.heardPending, id)
+			n.mu.Unlock()
+			for _, h := range hs {
+				(*h)(string(id))
+			}
+		})
+	}
+	if len(n.joinWaits) == 0 {
+		return n.fsm.receive(p)
+	}
+	var unknown []id // peers p brings news of that are not yet members
+	for _, m := range p.Msgs {
+		if m.Type == alive && m.NodeID != n.fsm.id && !n.fsm.isMember(m.NodeID) {
+			unknown = append(unknown, m.NodeID)
+		}
+	}
+	ps, ok := n.fsm.receive(p)
+	replied := n.fsm.isMember(p.remoteID)
+	for _, id := range unknown {
+		replied = replied || n.fsm.isMember(id)
+	}
+	if replied {
+		for c, addr := range n.joinWaits {
+			if sameAddr(addr, p.remoteAddr) {
+				select {
+				case c <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+	return ps, ok
 }
 
 // PostMemo disseminates a memo throughout the network. To ensure transmission
 // within a single UDP packet, PostMemo enforces a length limit of 500 bytes;
-// if len(b) exceeds this, PostMemo returns an error instead.
+// if len(b) exceeds this, PostMemo returns an error wrapping ErrMemoTooLong
+// instead.
+//
+// Each peer delivers each memo at most once, and delivers the memos posted by
+// n in the order in which PostMemo was called, including concurrently. To
+// preserve this order, a peer holds a memo that arrives before its
+// predecessors for up to a few protocol periods; if they have not arrived by
+// then, they are never delivered.
 func (n *Node) PostMemo(b []byte) error {
-	if len(b) > 500 {
-		return errors.New("body too long")
+	if len(b) > maxMemoLen {
+		return fmt.Errorf("%w: %v bytes exceeds limit of %v", ErrMemoTooLong, len(b), maxMemoLen)
 	}
 	n.mu.Lock()
 	defer n.mu.Unlock()
+	if n.closed {
+		return ErrNodeClosed
+	}
+	if n.fsm.observer {
+		return ErrObserver
+	}
+	if n.fsm.draining {
+		return ErrDraining
+	}
 	n.fsm.addMemo(b)
 	return nil
 }
 
+// UpdateAddr announces addr as n's address, for example after n migrates to a
+// new host or a NAT rebinds its public address. n increments its incarnation
+// number and disseminates news that it is alive at addr, so that peers send
+// to addr from then on, even if they learn of the change from other members.
+// n continues to receive packets on its local address; the caller is
+// responsible for making it reachable at addr. A WithAdvertiseFunc function
+// that returns a valid address takes precedence over addr.
+//
+// UpdateAddr returns an error wrapping ErrInvalidAddress if addr does not
+// have a specific IP address, or ErrObserver if n is an observer.
+func (n *Node) UpdateAddr(addr netip.AddrPort) error {
+	if a := addr.Addr(); !a.IsValid() || a.IsUnspecified() || a.IsMulticast() || addr.Port() == 0 {
+		return fmt.Errorf("%w %v: not a specific address", ErrInvalidAddress, addr)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return ErrNodeClosed
+	}
+	if n.fsm.observer {
+		return ErrObserver
+	}
+	n.fsm.updateAddr(addr)
+	return nil
+}
+
+// PostMemoWithID is like PostMemo, but identifies the memo by memoID rather
+// than by a random ID. Peers deliver at most one memo with a given ID, no
+// matter which node posted it, so an application that retries posting a memo,
+// perhaps after restarting, can use the same ID each time to avoid duplicate
+// deliveries. If n has already posted or received a memo with the same ID,
+// PostMemoWithID does nothing.
+//
+// memoID must be 1 to 64 bytes of printable ASCII, and should be unique to
+// the memo's content, for example by including the name of the application
+// and a sequence number of its own; otherwise, PostMemoWithID returns an
+// error wrapping ErrInvalidMemoID. Because a retried memo may reach a peer in
+// any order relative to other memos, memos posted with PostMemoWithID are
+// delivered as soon as they arrive, without regard to the order of n's
+// other memos.
+func (n *Node) PostMemoWithID(memoID string, b []byte) error {
+	if err := validateMemoID(memoID); err != nil {
+		return err
+	}
+	if len(b) > maxMemoLen {
+		return fmt.Errorf("%w: %v bytes exceeds limit of %v", ErrMemoTooLong, len(b), maxMemoLen)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return ErrNodeClosed
+	}
+	if n.fsm.observer {
+		return ErrObserver
+	}
+	if n.fsm.draining {
+		return ErrDraining
+	}
+	n.fsm.addMemoWithID(id(memoID), b)
+	return nil
+}
+
+// validateMemoID returns an error wrapping ErrInvalidMemoID if memoID cannot
+// identify a memo.
+func validateMemoID(memoID string) error {
+	if memoID == "" {
+		return fmt.Errorf("%w: empty", ErrInvalidMemoID)
+	}
+	if len(memoID) > maxMemoIDLen {
+		return fmt.Errorf("%w: %v bytes exceeds limit of %v", ErrInvalidMemoID, len(memoID), maxMemoIDLen)
+	}
+	for i := 0; i < len(memoID); i++ {
+		if c := memoID[i]; c <= ' ' || c > '~' {
+			return fmt.Errorf("%w: %q contains invalid character %q", ErrInvalidMemoID, memoID, c)
+		}
+	}
+	return nil
+}
+
+// Flush immediately sends any membership messages and memos awaiting
+// dissemination to a random peer, rather than waiting for the next protocol
+// period. To prevent floods, Flush does nothing if it was last called less
+// than 100 milliseconds ago.
+func (n *Node) Flush() error {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return ErrNodeClosed
+	}
+	var ps []packet
+	if now := time.Now(); now.Sub(n.lastFlush) >= minFlushInterval {
+		n.lastFlush = now
+		ps = n.fsm.flush()
+	}
+	n.mu.Unlock()
+	n.send(ps)
+	return nil
+}
+
+// Shutdown stops n's participation in the network and closes its connection.
+// After Shutdown, methods that communicate with the network return
+// ErrNodeClosed, as does Shutdown itself.
+func (n *Node) Shutdown() error {
+	n.mu.Lock()
+	n.closed = true
+	n.mu.Unlock()
+	if err := n.conn.Close(); err != nil {
+		if errors.Is(err, net.ErrClosed) {
+			return ErrNodeClosed
+		}
+		return err
+	}
+	return nil
+}
+
+// Drain gracefully removes n from the network and then calls Shutdown. Drain
+// proceeds in stages: first n stops accepting new memos and stops probing its
+// peers on behalf of the network, while continuing to acknowledge probes;
+// next, once the memos awaiting dissemination have been sent as many times as
+// required, n announces its departure; and finally, once the announcement has
+// been sent as many times as required, n shuts down. While draining, n sends
+// messages as often as Flush permits rather than once per protocol period.
+//
+// Peers report n's departure to their failure handlers with the reason Left.
+// If ctx expires before draining is complete, Drain shuts n down immediately
+// and returns ctx.Err(). While n is draining, PostMemo returns ErrDraining.
+func (n *Node) Drain(ctx context.Context) error {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return ErrNodeClosed
+	}
+	n.fsm.drain()
+	n.mu.Unlock()
+
+	t := time.NewTicker(minFlushInterval)
+	defer t.Stop()
+	for !n.drainStep() {
+		if err := n.Flush(); err != nil {
+			return err
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			n.Shutdown()
+			return ctx.Err()
+		}
+	}
+	return n.Shutdown()
+}
+
+// drainStep advances n to the next stage of draining, if the current stage is
+// complete, and reports whether draining is complete.
+func (n *Node) drainStep() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.fsm.members) == 0 {
+		return true
+	}
+	if !n.fsm.left {
+		if n.fsm.memoQueue.Len() > 0 {
+			return false
+		}
+		n.fsm.leave()
+	}
+	return !n.fsm.isAnnouncing()
+}
+
 // ID returns n's ID on the network.
 func (n *Node) ID() string {
 	return string(n.id)
@@ -222,12 +1140,21 @@ func (n *Node) ID() string {
 
 // LocalAddr returns the local network address.
 func (n *Node) LocalAddr() netip.AddrPort {
-	return n.conn.LocalAddr().(*net.UDPAddr).AddrPort()
+	switch a := n.conn.LocalAddr().(type) {
+	case *net.UDPAddr:
+		return a.AddrPort()
+	case nil:
+		return netip.AddrPort{}
+	default:
+		addr, _ := netip.ParseAddrPort(a.String())
+		return addr
+	}
 }
 
 type envelope struct {
-	SrcID id
-	P     packet
+	SrcID   id
+	Cluster string `json:",omitempty"`
+	P       packet
 }
 
 func stoppedTimer() *time.Timer {
@@ -237,3 +1164,14 @@ func stoppedTimer() *time.Timer {
 	}
 	return t
 }
+
+// stopTimer stops t and drains its channel, if necessary, so that t can be
+// reset.
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}
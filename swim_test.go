@@ -1,9 +1,16 @@
 package swim
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net"
 	"net/netip"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"kr.dev/diff"
 )
@@ -30,7 +37,7 @@ func TestOnJoin(t *testing.T) {
 	addr0 := n0.localAddrPort()
 
 	met1 := make(chan string)
-	n0.OnJoin(func(id string, _ netip.AddrPort) {
+	unregister := n0.OnJoin(func(id string, _ netip.AddrPort) {
 		met1 <- id
 	})
 
@@ -40,6 +47,7 @@ func TestOnJoin(t *testing.T) {
 	}
 	n1.Join(addr0)
 	diff.Test(t, t.Errorf, <-met1, n1.ID())
+	unregister()
 
 	type record struct {
 		id  string
@@ -58,6 +66,36 @@ func TestOnJoin(t *testing.T) {
 	diff.Test(t, t.Errorf, (<-met2).id, n2.ID())
 }
 
+func TestMultipleHandlers(t *testing.T) {
+	n, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan int, 3)
+	n.OnJoin(func(string, netip.AddrPort) { ch <- 1 })
+	unregister := n.OnJoin(func(string, netip.AddrPort) { ch <- 2 })
+	n.OnJoin(func(string, netip.AddrPort) { ch <- 3 })
+
+	n.receive(packet{
+		Type:     ping,
+		remoteID: "ABC",
+		Msgs:     []*message{{Type: alive, NodeID: "ABC"}},
+	})
+	for _, want := range []int{1, 2, 3} {
+		diff.Test(t, t.Errorf, <-ch, want)
+	}
+
+	unregister()
+	n.receive(packet{
+		Type:     ping,
+		remoteID: "DEF",
+		Msgs:     []*message{{Type: alive, NodeID: "DEF"}},
+	})
+	for _, want := range []int{1, 3} {
+		diff.Test(t, t.Errorf, <-ch, want)
+	}
+}
+
 func TestHandlerOrder(t *testing.T) {
 	n, err := Start("")
 	if err != nil {
@@ -87,6 +125,143 @@ func TestHandlerOrder(t *testing.T) {
 	diff.Test(t, t.Errorf, <-ch, failedUpdate)
 }
 
+func TestSyncHandlers(t *testing.T) {
+	n, err := Start("", WithSyncHandlers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan update, 8)
+	n.OnJoin(func(id string, _ netip.AddrPort) {
+		ch <- update{typ: joinedUpdate, nodeID: id}
+	})
+	n.OnMemo(func(id string, _ netip.AddrPort, memo []byte) {
+		ch <- update{typ: sentMemoUpdate, nodeID: id, memo: memo}
+	})
+
+	n.receive(packet{
+		Type:     ping,
+		remoteID: "ABC",
+		Msgs: []*message{
+			{Type: alive, NodeID: "ABC", MemoID: "1", Body: []byte("one")},
+			{Type: alive, NodeID: "DEF", MemoID: "2", Body: []byte("two")},
+			{Type: alive, NodeID: "ABC", MemoID: "3", Body: []byte("three")},
+		},
+	})
+
+	for _, want := range []update{
+		{typ: joinedUpdate, nodeID: "ABC"},
+		{typ: sentMemoUpdate, nodeID: "ABC", memo: []byte("one")},
+		{typ: joinedUpdate, nodeID: "DEF"},
+		{typ: sentMemoUpdate, nodeID: "DEF", memo: []byte("two")},
+		{typ: sentMemoUpdate, nodeID: "ABC", memo: []byte("three")},
+	} {
+		diff.Test(t, t.Errorf, <-ch, want)
+	}
+}
+
+func TestHandlerConcurrency(t *testing.T) {
+	const k = 2
+	n, err := Start("", WithHandlerConcurrency(k))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu        sync.Mutex
+		active    int
+		maxActive int
+		joined    = make(map[string]bool)
+	)
+	enter := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if active++; active > maxActive {
+			maxActive = active
+		}
+	}
+	exit := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		active--
+	}
+	done := make(chan bool)
+	n.OnJoin(func(id string, _ netip.AddrPort) {
+		enter()
+		defer exit()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		joined[id] = true
+		mu.Unlock()
+	})
+	n.OnMemo(func(id string, _ netip.AddrPort, _ []byte) {
+		enter()
+		defer exit()
+		mu.Lock()
+		ok := joined[id]
+		mu.Unlock()
+		done <- ok
+	})
+
+	const nPeers = 10
+	var msgs []*message
+	for i := 0; i < nPeers; i++ {
+		msgs = append(msgs, &message{
+			Type:   alive,
+			NodeID: randID(),
+			MemoID: randID(),
+			Body:   []byte("Hello, SWIM!"),
+		})
+	}
+	n.receive(packet{Type: ping, remoteID: msgs[0].NodeID, Msgs: msgs})
+	for i := 0; i < nPeers; i++ {
+		if !<-done {
+			t.Error("memo handler called before join handler returned")
+		}
+	}
+	if maxActive > k {
+		t.Errorf("%v concurrent handler calls; expected at most %v", maxActive, k)
+	}
+}
+
+func TestHandlerCallsNode(t *testing.T) {
+	n, err := Start("", WithSyncHandlers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const nPeers = 500
+	joins := make(chan int, nPeers)
+	n.OnJoin(func(string, netip.AddrPort) { joins <- len(n.Members()) })
+
+	// A burst of joins queues many more handler calls than the handler
+	// goroutine can keep up with, each of which needs n's lock
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < nPeers; i++ {
+			id := randID()
+			n.receive(packet{Type: ping, remoteID: id, Msgs: []*message{{Type: alive, NodeID: id}}})
+		}
+	}()
+	timeout := time.After(10 * time.Second)
+	for i := 0; i < nPeers; i++ {
+		select {
+		case <-joins:
+		case <-timeout:
+			t.Fatalf("deadlocked after %v of %v joins", i, nPeers)
+		}
+	}
+	<-done
+	shutdown := make(chan struct{})
+	go func() {
+		n.Shutdown()
+		close(shutdown)
+	}()
+	select {
+	case <-shutdown:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown deadlocked")
+	}
+}
+
 func TestDetectJoinAndFail(t *testing.T) {
 	nodes, chans := launch(2)
 	addr0 := nodes[0].localAddrPort()
@@ -144,6 +319,87 @@ func TestPostMemo(t *testing.T) {
 	diff.Test(t, t.Errorf, <-chans[2], u)
 }
 
+func TestErrors(t *testing.T) {
+	n, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.PostMemo(make([]byte, 501)); !errors.Is(err, ErrMemoTooLong) {
+		t.Errorf("PostMemo(501 bytes): got %v, expected %v", err, ErrMemoTooLong)
+	}
+	for _, addr := range []string{"0.0.0.0:1000", "224.0.0.1:1000", "127.0.0.1:0"} {
+		if err := n.UpdateAddr(netip.MustParseAddrPort(addr)); !errors.Is(err, ErrInvalidAddress) {
+			t.Errorf("UpdateAddr(%v): got %v, expected %v", addr, err, ErrInvalidAddress)
+		}
+	}
+	n.conn.Close()
+	if err := n.Join(n.localAddrPort()); !errors.Is(err, ErrNodeClosed) {
+		t.Errorf("Join after Close: got %v, expected %v", err, ErrNodeClosed)
+	}
+}
+
+func TestFlushMemo(t *testing.T) {
+	nodes, chans := launch(2)
+	for _, n := range nodes {
+		defer n.Shutdown()
+	}
+	nodes[1].Join(nodes[0].localAddrPort())
+	<-chans[0]
+	<-chans[1]
+	// Stop both protocol loops, so that only Flush can send the memo, and let
+	// packets already in flight arrive
+	for _, n := range nodes {
+		n.stopTick <- struct{}{}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	s := "Hello, SWIM!"
+	nodes[0].PostMemo([]byte(s))
+	if err := nodes[0].Flush(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case u := <-chans[1]:
+		diff.Test(t, t.Errorf, u, update{typ: sentMemoUpdate, nodeID: string(nodes[0].id), memo: []byte(s)})
+	case <-time.After(time.Second):
+		t.Error("memo not delivered")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	n, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := n.localAddrPort()
+	if err := n.Shutdown(); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	for _, tt := range []struct {
+		name string
+		f    func() error
+	}{
+		{"Join", func() error { return n.Join(addr) }},
+		{"PostMemo", func() error { return n.PostMemo([]byte("Hello, SWIM!")) }},
+		{"RemoveMember", func() error { return n.RemoveMember("XYZ") }},
+		{"UpdateAddr", func() error { return n.UpdateAddr(addr) }},
+		{"Shutdown", n.Shutdown},
+	} {
+		if err := tt.f(); !errors.Is(err, ErrNodeClosed) {
+			t.Errorf("%v after Shutdown: got %v, expected %v", tt.name, err, ErrNodeClosed)
+		}
+	}
+	if ps := n.tick(); ps != nil {
+		t.Errorf("tick after Shutdown: got %v, expected nil", ps)
+	}
+	if ps := n.timeout(); ps != nil {
+		t.Errorf("timeout after Shutdown: got %v, expected nil", ps)
+	}
+	if ps, ok := n.receive(packet{Type: ping, remoteID: "XYZ"}); ps != nil || ok {
+		t.Errorf("receive after Shutdown: got %v, %v; expected nil, false", ps, ok)
+	}
+}
+
 func launch(n int) ([]*Node, []chan update) {
 	nodes := make([]*Node, n)
 	chans := make([]chan update, n)
@@ -173,3 +429,711 @@ func (n *Node) localAddrPort() netip.AddrPort {
 	u.IP = net.IPv6loopback
 	return u.AddrPort()
 }
+
+func TestInvalidSource(t *testing.T) {
+	n, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	b, err := json.Marshal(envelope{
+		SrcID: "XYZ",
+		P:     packet{Type: ack, Msgs: []*message{{Type: alive, NodeID: "XYZ"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid := []string{
+		"0.0.0.0:1000",
+		"[::]:1000",
+		"224.0.0.1:1000",
+		"[ff02::1]:1000",
+		"[::ffff:224.0.0.1]:1000",
+		"127.0.0.1:0",
+	}
+	for _, s := range invalid {
+		n.handleDatagram(b, netip.MustParseAddrPort(s))
+	}
+	n.handleDatagram(b, netip.AddrPort{})
+	n.mu.Lock()
+	isMember := n.fsm.isMember("XYZ")
+	n.mu.Unlock()
+	if isMember {
+		t.Fatal("packet from invalid source accepted")
+	}
+	if got, want := n.Stats().PacketsRejected, uint64(len(invalid)+1); got != want {
+		t.Errorf("PacketsRejected: got %v, expected %v", got, want)
+	}
+
+	n.handleDatagram(b, netip.MustParseAddrPort("127.0.0.1:1000"))
+	n.mu.Lock()
+	isMember = n.fsm.isMember("XYZ")
+	n.mu.Unlock()
+	if !isMember {
+		t.Error("packet from valid source rejected")
+	}
+}
+
+func TestJoinAndWait(t *testing.T) {
+	n0, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n0.Shutdown()
+	n1, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n1.Shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := n1.JoinAndWait(ctx, n0.localAddrPort()); err != nil {
+		t.Fatalf("JoinAndWait: %v", err)
+	}
+
+	// A seed that advertises an address other than the one dialed
+	public := netip.MustParseAddrPort("[2001:db8::1]:7946")
+	n2, err := Start("", WithAdvertiseFunc(func(netip.AddrPort) netip.AddrPort { return public }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n2.Shutdown()
+	n3, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n3.Shutdown()
+	if err := n3.JoinAndWait(ctx, n2.localAddrPort()); err != nil {
+		t.Fatalf("JoinAndWait with advertised address: %v", err)
+	}
+	var addr2 netip.AddrPort
+	for _, m := range n3.Members() {
+		if m.ID == n2.ID() {
+			addr2 = m.Addr
+		}
+	}
+	diff.Test(t, t.Errorf, addr2, public)
+
+	// A seed that never responds
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := n1.JoinAndWait(ctx, conn.LocalAddr().(*net.UDPAddr).AddrPort()); !errors.Is(err, ErrJoinTimeout) {
+		t.Errorf("JoinAndWait with silent seed: got %v, expected %v", err, ErrJoinTimeout)
+	}
+}
+
+func TestObserver(t *testing.T) {
+	n0, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n0.Shutdown()
+	obs, err := Start("", WithObserverMode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obs.Shutdown()
+	joined := make(chan string, 1)
+	obs.OnJoin(func(id string, _ netip.AddrPort) { joined <- id })
+
+	obs.Join(n0.localAddrPort())
+	diff.Test(t, t.Errorf, <-joined, n0.ID())
+	if ms := n0.Members(); len(ms) != 1 {
+		t.Errorf("n0 members: got %v, expected only n0", ms)
+	}
+	if ids := obs.SortedMembers(); len(ids) != 1 || ids[0] != n0.ID() {
+		t.Errorf("observer members: got %v, expected [%v]", ids, n0.ID())
+	}
+	if err := obs.PostMemo([]byte("Hello, SWIM!")); !errors.Is(err, ErrObserver) {
+		t.Errorf("PostMemo: got %v, expected %v", err, ErrObserver)
+	}
+}
+
+func TestFirstProbe(t *testing.T) {
+	n, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	// Let n begin its first protocol period alone
+	time.Sleep(50 * time.Millisecond)
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	b, err := json.Marshal(envelope{
+		SrcID: "XYZ",
+		P:     packet{Type: ping, Msgs: []*message{{Type: alive, NodeID: "XYZ"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := conn.WriteToUDPAddrPort(b, n.localAddrPort()); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(start.Add(tickAverage / 2))
+	buf := make([]byte, maxReceiveBufferSize)
+	for {
+		len, _, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			t.Fatalf("no probe within %v of joining: %v", tickAverage/2, err)
+		}
+		var e envelope
+		if err := json.Unmarshal(buf[:len], &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.P.Type == ping {
+			t.Logf("first probe after %v", time.Since(start))
+			return
+		}
+	}
+}
+
+func TestRepeatedJoin(t *testing.T) {
+	n, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	seed := conn.LocalAddr().(*net.UDPAddr).AddrPort()
+	for i := 0; i < 10; i++ {
+		if err := n.Join(seed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := make([]byte, maxReceiveBufferSize)
+	var received int
+	conn.SetReadDeadline(time.Now().Add(minJoinInterval / 2))
+	for {
+		if _, _, err := conn.ReadFromUDPAddrPort(b); err != nil {
+			break
+		}
+		received++
+	}
+	if received != 1 {
+		t.Errorf("seed received %v join requests, expected 1", received)
+	}
+
+	time.Sleep(minJoinInterval)
+	n.Join(seed)
+	conn.SetReadDeadline(time.Now().Add(minJoinInterval / 2))
+	if _, _, err := conn.ReadFromUDPAddrPort(b); err != nil {
+		t.Errorf("no join request after interval: %v", err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	n0, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n0.Shutdown()
+	n1, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n1.Shutdown()
+	reasons := make(chan FailReason, 1)
+	n0.OnFailReason(func(_ string, reason FailReason) { reasons <- reason })
+	memos := make(chan string, 1)
+	n0.OnMemo(func(_ string, _ netip.AddrPort, memo []byte) { memos <- string(memo) })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := n1.JoinAndWait(ctx, n0.localAddrPort()); err != nil {
+		t.Fatalf("JoinAndWait: %v", err)
+	}
+
+	if err := n1.PostMemo([]byte("goodbye")); err != nil {
+		t.Fatal(err)
+	}
+	if err := n1.Drain(ctx); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	if err := n1.PostMemo(nil); !errors.Is(err, ErrNodeClosed) {
+		t.Errorf("PostMemo after Drain: got %v, expected %v", err, ErrNodeClosed)
+	}
+	diff.Test(t, t.Errorf, <-memos, "goodbye")
+	select {
+	case reason := <-reasons:
+		diff.Test(t, t.Errorf, reason, Left)
+	case <-ctx.Done():
+		t.Error("departure not detected")
+	}
+}
+
+func TestAdvertiseFunc(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	seed := conn.LocalAddr().(*net.UDPAddr).AddrPort()
+	public := netip.MustParseAddrPort("[2001:db8::1]:7946")
+	n, err := Start("", WithAdvertiseFunc(func(peer netip.AddrPort) netip.AddrPort {
+		if peer == seed {
+			return public
+		}
+		return netip.AddrPort{}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	if err := n.Join(seed); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, maxReceiveBufferSize)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	nb, _, err := conn.ReadFromUDPAddrPort(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e envelope
+	if err := json.Unmarshal(b[:nb], &e); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.P.Msgs) != 1 || e.P.Msgs[0].Addr != public {
+		t.Errorf("join request: got %+v, expected alive message with address %v", e.P.Msgs, public)
+	}
+}
+
+// BenchmarkSend measures the time to send a batch of 50 packets, as large
+// gossip fanouts produce.
+func BenchmarkSend(b *testing.B) {
+	n, err := Start("[::1]:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer n.Shutdown()
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer sink.Close()
+	go func() {
+		buf := make([]byte, maxReceiveBufferSize)
+		for {
+			if _, _, err := sink.ReadFromUDPAddrPort(buf); err != nil {
+				return
+			}
+		}
+	}()
+	addr := sink.LocalAddr().(*net.UDPAddr).AddrPort()
+	ps := make([]packet, 50)
+	for i := range ps {
+		ps[i] = packet{
+			Type:       gossip,
+			remoteAddr: addr,
+			Msgs:       []*message{{Type: alive, NodeID: randID(), Addr: addr}},
+		}
+	}
+	for _, bb := range []struct {
+		name string
+		send func([]packet)
+	}{
+		{"Each", n.sendEach},
+		{"Parallel", n.sendParallel},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				bb.send(ps)
+			}
+		})
+	}
+}
+
+func TestFailReasonString(t *testing.T) {
+	for r, want := range map[FailReason]string{
+		Failed: "failed",
+		Left:   "left",
+		7:      "FailReason(7)",
+	} {
+		if got := r.String(); got != want {
+			t.Errorf("%d.String(): got %q, expected %q", byte(r), got, want)
+		}
+	}
+}
+
+func TestOnFailReason(t *testing.T) {
+	n, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	type record struct {
+		id     string
+		reason FailReason
+	}
+	records := make(chan record, 2)
+	n.OnFailReason(func(id string, reason FailReason) { records <- record{id, reason} })
+	fails := make(chan string, 2)
+	n.OnFail(func(id string) { fails <- id })
+	addr := netip.MustParseAddrPort("127.0.0.1:1000")
+	n.receive(packet{
+		Type:       gossip,
+		remoteID:   "AAA",
+		remoteAddr: addr,
+		Msgs: []*message{
+			{Type: alive, NodeID: "AAA", Addr: addr},
+			{Type: alive, NodeID: "BBB", Addr: addr},
+		},
+	})
+
+	for _, tt := range []record{{"AAA", Failed}, {"BBB", Left}} {
+		n.receive(packet{
+			Type:       gossip,
+			remoteID:   "CCC",
+			remoteAddr: addr,
+			Msgs:       []*message{{Type: failed, NodeID: id(tt.id), Reason: tt.reason}},
+		})
+		diff.Test(t, t.Errorf, <-records, tt)
+		diff.Test(t, t.Errorf, <-fails, tt.id)
+	}
+}
+
+// A flakyConn is a Transport whose reads return the errors sent on errs,
+// and net.ErrClosed once errs is closed.
+type flakyConn struct {
+	errs chan error
+}
+
+func (c *flakyConn) ReadFromUDPAddrPort(b []byte) (int, netip.AddrPort, error) {
+	if err, ok := <-c.errs; ok {
+		return 0, netip.AddrPort{}, err
+	}
+	return 0, netip.AddrPort{}, net.ErrClosed
+}
+
+func (c *flakyConn) WriteToUDPAddrPort(b []byte, addr netip.AddrPort) (int, error) {
+	return len(b), nil
+}
+
+func (c *flakyConn) Close() error { return nil }
+
+func (c *flakyConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv6loopback, Port: 7946}
+}
+
+func TestTransientReadError(t *testing.T) {
+	conn := &flakyConn{errs: make(chan error)}
+	n := start(conn, defaultConfig())
+	defer n.Shutdown()
+	errs := make(chan error, 1)
+	n.OnError(func(err error) { errs <- err })
+
+	conn.errs <- &net.OpError{Op: "read", Net: "udp", Err: syscall.ECONNREFUSED}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, syscall.ECONNREFUSED) {
+			t.Errorf("got error %v, expected ECONNREFUSED", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("transient read error not reported")
+	}
+	if got := n.Stats().ReadErrors; got != 1 {
+		t.Errorf("ReadErrors: got %v, expected 1", got)
+	}
+
+	// The Node continues to read after a transient error
+	select {
+	case conn.errs <- errors.New("permanent"):
+	case <-time.After(time.Second):
+		t.Fatal("Node stopped reading after transient error")
+	}
+	select {
+	case conn.errs <- nil:
+		t.Error("Node continued reading after permanent error")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want bool
+	}{
+		{net.ErrClosed, false},
+		{&net.OpError{Op: "read", Err: net.ErrClosed}, false},
+		{errors.New("permanent"), false},
+		{syscall.EINTR, true},
+		{&net.OpError{Op: "read", Err: syscall.ECONNREFUSED}, true},
+	} {
+		if got := isTransient(tt.err); got != tt.want {
+			t.Errorf("isTransient(%v): got %v, expected %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestReadLoopTruncated(t *testing.T) {
+	mt := NewMemTransport()
+	lo := netip.IPv6Loopback()
+	src, err := mt.Listen(netip.AddrPortFrom(lo, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := mt.Listen(netip.AddrPortFrom(lo, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := dst.LocalAddr().(*net.UDPAddr).AddrPort()
+	for _, size := range []int{32, 16, 8} {
+		src.WriteToUDPAddrPort(make([]byte, size), addr)
+	}
+
+	// Datagrams that fill the buffer may have been truncated
+	var got []int
+	readLoop(dst, 16, nil, func(b []byte, _ netip.AddrPort) bool {
+		if b == nil {
+			got = append(got, -1)
+		} else {
+			got = append(got, len(b))
+		}
+		return len(got) < 3
+	})
+	diff.Test(t, t.Errorf, got, []int{-1, -1, 8})
+}
+
+func TestProbeSeed(t *testing.T) {
+	// probeOrder returns the first targets n probes among the same peers
+	probeOrder := func(n *Node) []id {
+		var msgs []*message
+		for i := 0; i < 8; i++ {
+			msgs = append(msgs, &message{Type: alive, NodeID: id(fmt.Sprint(i))})
+		}
+		// Hold the lock so that the Node cannot probe in the meantime
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		n.fsm.receive(packet{Type: ping, remoteID: "0", Msgs: msgs})
+		var ids []id
+		for i := 0; i < 16; i++ {
+			ids = append(ids, n.fsm.order.Next())
+		}
+		return ids
+	}
+	var orders [][]id
+	for _, opts := range [][]Option{
+		{WithProbeSeed(1)},
+		{WithProbeSeed(1)},
+		nil,
+		nil,
+	} {
+		n, err := Start("", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer n.Shutdown()
+		orders = append(orders, probeOrder(n))
+	}
+	diff.Test(t, t.Errorf, orders[0], orders[1])
+	if fmt.Sprint(orders[2]) == fmt.Sprint(orders[3]) {
+		t.Errorf("Nodes with different IDs probed in the same order: %v", orders[2])
+	}
+}
+
+func TestLastTick(t *testing.T) {
+	before := time.Now()
+	n, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	deadline := time.Now().Add(time.Second)
+	for n.LastTick().IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatal("no protocol period begun")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if last := n.LastTick(); last.Before(before) || time.Since(last) > time.Second {
+		t.Errorf("LastTick: got %v, expected within a second of %v", last, before)
+	}
+	if d := n.Stats().SinceTick; d <= 0 || d > time.Second {
+		t.Errorf("SinceTick: got %v, expected positive and at most 1s", d)
+	}
+}
+
+func TestRemoveMember(t *testing.T) {
+	n, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	reasons := make(chan FailReason, 1)
+	n.OnFailReason(func(_ string, reason FailReason) { reasons <- reason })
+	addr := netip.MustParseAddrPort("127.0.0.1:1000")
+	n.receive(packet{Type: gossip, remoteID: "AAA", remoteAddr: addr, Msgs: []*message{{Type: alive, NodeID: "AAA"}}})
+
+	if err := n.RemoveMember("AAA"); err != nil {
+		t.Fatal(err)
+	}
+	if reason := <-reasons; reason != Failed {
+		t.Errorf("fail handler: got reason %v, expected %v", reason, Failed)
+	}
+	if ms := n.Members(); len(ms) != 1 {
+		t.Errorf("Members: got %v, expected only n", ms)
+	}
+	n.mu.Lock()
+	var news *message
+	n.fsm.msgQueue.Range(func(key id, m *message, _ int) bool {
+		if key == "AAA" {
+			news = m
+		}
+		return news == nil
+	})
+	n.mu.Unlock()
+	if news == nil || news.Type != failed {
+		t.Errorf("queued news of AAA: got %+v, expected failure", news)
+	}
+
+	if err := n.RemoveMember("AAA"); !errors.Is(err, ErrNotMember) {
+		t.Errorf("RemoveMember of non-member: got %v, expected %v", err, ErrNotMember)
+	}
+	if err := n.RemoveMember(n.ID()); !errors.Is(err, ErrNotMember) {
+		t.Errorf("RemoveMember of self: got %v, expected %v", err, ErrNotMember)
+	}
+}
+
+func TestOnUpdate(t *testing.T) {
+	n, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	ch := make(chan Update, 3)
+	n.OnUpdate(func(u Update) { ch <- u })
+	addr := netip.MustParseAddrPort("127.0.0.1:1000")
+	n.receive(packet{
+		Type:       ping,
+		remoteID:   "XYZ",
+		remoteAddr: addr,
+		Msgs: []*message{
+			{Type: alive, NodeID: "XYZ", Incarnation: 2, MemoID: "123", Body: []byte("memo")},
+			{Type: failed, NodeID: "XYZ", Reason: Left},
+		},
+	})
+	for _, want := range []Update{
+		{Kind: JoinUpdate, NodeID: "XYZ", Addr: addr, Incarnation: 2},
+		{Kind: MemoUpdate, NodeID: "XYZ", Addr: addr, Incarnation: 2, Memo: []byte("memo")},
+		{Kind: FailUpdate, NodeID: "XYZ", Addr: addr, Incarnation: 2, Reason: Left},
+	} {
+		diff.Test(t, t.Errorf, <-ch, want)
+	}
+}
+
+func TestUpdateWithoutJoin(t *testing.T) {
+	n, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	ch := make(chan Update, 2)
+	n.OnUpdate(func(u Update) { ch <- u })
+
+	// The state machine reports a memo and a failure of a peer whose join
+	// it never reported
+	n.mu.Lock()
+	n.fsm.handleMemo("XYZ", netip.AddrPort{}, []byte("memo"))
+	n.fsm.handleFail("XYZ", Failed)
+	n.mu.Unlock()
+	diff.Test(t, t.Errorf, <-ch, Update{Kind: MemoUpdate, NodeID: "XYZ", Memo: []byte("memo")})
+	diff.Test(t, t.Errorf, <-ch, Update{Kind: FailUpdate, NodeID: "XYZ"})
+}
+
+func TestWithConn(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n0, err := Start("invalid address", WithConn(conn), WithReadBufferSize(1<<16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n0.Shutdown()
+	if got, want := n0.LocalAddr(), conn.LocalAddr().(*net.UDPAddr).AddrPort(); got != want {
+		t.Errorf("LocalAddr: got %v, expected %v", got, want)
+	}
+	n1, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n1.Shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := n1.JoinAndWait(ctx, n0.LocalAddr()); err != nil {
+		t.Fatalf("JoinAndWait: %v", err)
+	}
+
+	// The Node closes conn when it shuts down
+	n0.Shutdown()
+	if _, err := conn.WriteToUDPAddrPort([]byte{0}, n1.LocalAddr()); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("write after Shutdown: got %v, expected %v", err, net.ErrClosed)
+	}
+
+	// A conn that does not implement Transport is rejected
+	pc := struct{ net.PacketConn }{conn}
+	if _, err := Start("", WithConn(pc)); err == nil {
+		t.Error("Start with non-Transport conn: got nil error")
+	}
+}
+
+func TestOnHeard(t *testing.T) {
+	n, err := Start("", WithSyncHandlers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+	heard := make(chan string, 4)
+	release := make(chan struct{})
+	n.OnHeard(func(nodeID string) {
+		heard <- nodeID
+		<-release
+	})
+	expect := func(want ...string) {
+		t.Helper()
+		var got []string
+		for len(got) < len(want) {
+			select {
+			case nodeID := <-heard:
+				got = append(got, nodeID)
+			case <-time.After(time.Second):
+				t.Fatalf("heard %v, expected %v", got, want)
+			}
+		}
+		select {
+		case nodeID := <-heard:
+			got = append(got, nodeID)
+		case <-time.After(50 * time.Millisecond):
+		}
+		diff.Test(t, t.Errorf, got, want)
+	}
+
+	// A packet that introduces its sender is not reported, as the sender is
+	// not yet a member when it arrives
+	n.receive(packet{Type: ping, remoteID: "AAA", Msgs: []*message{{Type: alive, NodeID: "AAA"}}})
+	n.receive(packet{Type: ack, remoteID: "AAA"})
+	expect("AAA")
+
+	// While the handler is busy, a call for the next packet is queued, and
+	// those that arrive after it are coalesced with it
+	n.receive(packet{Type: ping, remoteID: "AAA"})
+	n.receive(packet{Type: ack, remoteID: "AAA"})
+	n.receive(packet{Type: ack, remoteID: "BBB"})
+	release <- struct{}{}
+	expect("AAA")
+	release <- struct{}{}
+	expect()
+	close(release)
+}
@@ -0,0 +1,105 @@
+package swim
+
+import "net/netip"
+
+// A Direction describes whether a traced packet was sent or received.
+type Direction byte
+
+const (
+	Inbound  Direction = iota // received by the Node
+	Outbound                  // sent by the Node
+)
+
+func (d Direction) String() string {
+	if d == Outbound {
+		return "outbound"
+	}
+	return "inbound"
+}
+
+// A TracePacket is a decoded packet passed to a packet trace function.
+type TracePacket struct {
+	Type   string // "ping", "ping-req", "ack", or "gossip"
+	PeerID string // the sender of an inbound packet, or the intended recipient of an outbound one, if known
+
+	// TargetID and TargetAddr identify the peer to be probed by a ping
+	// request, or that acknowledged a relayed probe.
+	TargetID   string
+	TargetAddr netip.AddrPort
+
+	// ProbeSeq identifies the probe to which a ping, ping request, or ack
+	// belongs, or is 0 if the packet belongs to none.
+	ProbeSeq uint64
+
+	// Observer reports whether the sender is an observer.
+	Observer bool
+
+	Msgs []TraceMessage
+}
+
+// A TraceMessage is a message carried by a TracePacket.
+type TraceMessage struct {
+	Type        string // "alive", "suspected", or "failed"
+	NodeID      string
+	Addr        netip.AddrPort
+	Incarnation int
+	MemoID      string     // the ID of the memo carried by the message, if any
+	MemoLen     int        // the length of the memo carried by the message
+	Reason      FailReason // for failed messages
+	Origin      string     // for suspected messages, the ID of the suspicion's originator, if known
+}
+
+// trace passes p, sent to or received from addr, to n's packet trace
+// function, if it has one.
+func (n *Node) trace(dir Direction, addr netip.AddrPort, p packet) {
+	if n.tracer == nil {
+		return
+	}
+	tp := TracePacket{
+		Type:       p.Type.String(),
+		PeerID:     string(p.remoteID),
+		TargetID:   string(p.TargetID),
+		TargetAddr: p.TargetAddr,
+		ProbeSeq:   p.ProbeSeq,
+		Observer:   p.Observer,
+	}
+	for _, m := range p.Msgs {
+		tp.Msgs = append(tp.Msgs, TraceMessage{
+			Type:        m.Type.String(),
+			NodeID:      string(m.NodeID),
+			Addr:        m.Addr,
+			Incarnation: m.Incarnation,
+			MemoID:      string(m.MemoID),
+			MemoLen:     len(m.Body),
+			Reason:      m.Reason,
+			Origin:      string(m.Origin),
+		})
+	}
+	n.tracer(dir, addr, tp)
+}
+
+func (t packetType) String() string {
+	switch t {
+	case ping:
+		return "ping"
+	case pingReq:
+		return "ping-req"
+	case ack:
+		return "ack"
+	case gossip:
+		return "gossip"
+	}
+	return "unknown"
+}
+
+func (t msgType) String() string {
+	switch t {
+	case alive:
+		return "alive"
+	case suspected:
+		return "suspected"
+	case failed:
+		return "failed"
+	}
+	return "unknown"
+}
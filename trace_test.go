@@ -0,0 +1,55 @@
+package swim
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPacketTrace(t *testing.T) {
+	type event struct {
+		dir Direction
+		p   TracePacket
+	}
+	var mu sync.Mutex
+	var events []event
+	n0, err := Start("", WithPacketTrace(func(dir Direction, _ netip.AddrPort, p TracePacket) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event{dir, p})
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n0.Shutdown()
+	n1, err := Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n1.Shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := n0.JoinAndWait(ctx, n1.localAddrPort()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) < 2 {
+		t.Fatalf("got %v traced packets, expected at least 2", len(events))
+	}
+	out := events[0]
+	if out.dir != Outbound || out.p.Type != "ping" || len(out.p.Msgs) != 1 ||
+		out.p.Msgs[0].Type != "alive" || out.p.Msgs[0].NodeID != n0.ID() {
+		t.Errorf("join request: got %v %+v, expected outbound ping announcing %v", out.dir, out.p, n0.ID())
+	}
+	var acked bool
+	for _, e := range events {
+		acked = acked || e.dir == Inbound && e.p.Type == "ack" && e.p.PeerID == n1.ID()
+	}
+	if !acked {
+		t.Errorf("no inbound ack from %v among %+v", n1.ID(), events)
+	}
+}
@@ -0,0 +1,189 @@
+package swim
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// memQueueLen is the number of packets a MemTransport queues for each
+// connection before it begins to discard them.
+const memQueueLen = 64
+
+// A Transport carries a Node's packets. It is modeled on a UDP socket, and
+// *net.UDPConn implements Transport. Reads must block until a packet arrives
+// or the Transport is closed, after which they must return an error wrapping
+// net.ErrClosed. LocalAddr must return the address at which peers can reach
+// the Transport, preferably as a *net.UDPAddr.
+type Transport interface {
+	ReadFromUDPAddrPort(b []byte) (n int, addr netip.AddrPort, err error)
+	WriteToUDPAddrPort(b []byte, addr netip.AddrPort) (int, error)
+	Close() error
+	LocalAddr() net.Addr
+}
+
+// StartTransport creates a new Node that sends and receives packets through
+// t rather than a UDP socket of its own. The Node closes t when it shuts
+// down. WithReadBufferSize and WithWriteBufferSize have no effect on the
+// Node.
+func StartTransport(t Transport, opts ...Option) (*Node, error) {
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return start(t, cfg), nil
+}
+
+// A MemTransport is an in-memory network that delivers packets among the
+// Transports it creates through channels, with configurable loss and latency.
+// It lets Nodes in a single process form a network without sockets, as in
+// tests. A MemTransport is safe for concurrent use.
+type MemTransport struct {
+	mu       sync.Mutex // protects the following fields
+	conns    map[netip.AddrPort]*memConn
+	nextPort uint16
+	loss     float64
+	latency  time.Duration
+}
+
+// NewMemTransport returns a new MemTransport that delivers every packet
+// immediately.
+func NewMemTransport() *MemTransport {
+	return &MemTransport{
+		conns:    make(map[netip.AddrPort]*memConn),
+		nextPort: 1,
+	}
+}
+
+// SetLoss sets the probability p that m discards a packet rather than
+// delivering it. SetLoss panics if p is not between 0 and 1.
+func (m *MemTransport) SetLoss(p float64) {
+	if !(p >= 0 && p <= 1) {
+		panic(fmt.Sprintf("swim: loss probability %v not between 0 and 1", p))
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loss = p
+}
+
+// SetLatency sets the time m takes to deliver each packet.
+func (m *MemTransport) SetLatency(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latency = d
+}
+
+// Listen returns a new Transport that receives the packets sent through m to
+// addr, which must have a specific IP address, such as 127.0.0.1, because
+// Nodes reject packets from unspecified addresses. If addr's port is 0, a
+// port number is automatically chosen. Listen returns an error if addr is in
+// use.
+func (m *MemTransport) Listen(addr netip.AddrPort) (Transport, error) {
+	if a := addr.Addr(); !a.IsValid() || a.IsUnspecified() || a.IsMulticast() {
+		return nil, fmt.Errorf("%w %v: invalid host", ErrInvalidAddress, addr)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if addr.Port() == 0 {
+		for {
+			addr = netip.AddrPortFrom(addr.Addr(), m.nextPort)
+			if m.nextPort++; m.nextPort == 0 {
+				m.nextPort = 1
+			}
+			if _, ok := m.conns[addr]; !ok {
+				break
+			}
+		}
+	}
+	if _, ok := m.conns[addr]; ok {
+		return nil, fmt.Errorf("address %v in use", addr)
+	}
+	c := &memConn{
+		m:    m,
+		addr: addr,
+		in:   make(chan datagram, memQueueLen),
+		done: make(chan struct{}),
+	}
+	m.conns[addr] = c
+	return c, nil
+}
+
+// send delivers b from src to dst, subject to m's loss and latency. Packets
+// addressed to no Transport are discarded.
+func (m *MemTransport) send(b []byte, src, dst netip.AddrPort) {
+	m.mu.Lock()
+	c, ok := m.conns[dst]
+	loss, latency := m.loss, m.latency
+	m.mu.Unlock()
+	if !ok || rand.Float64() < loss {
+		return
+	}
+	d := datagram{append([]byte(nil), b...), src}
+	if latency <= 0 {
+		c.deliver(d)
+		return
+	}
+	time.AfterFunc(latency, func() { c.deliver(d) })
+}
+
+// A memConn is a Transport created by a MemTransport.
+type memConn struct {
+	m    *MemTransport
+	addr netip.AddrPort
+	in   chan datagram
+	done chan struct{} // closed when c is closed
+}
+
+// deliver queues d for c to read, unless c is closed or its queue is full.
+func (c *memConn) deliver(d datagram) {
+	select {
+	case <-c.done:
+	case c.in <- d:
+	default:
+		// Queue full; discard the packet as an overflowing socket
+		// buffer would
+	}
+}
+
+func (c *memConn) ReadFromUDPAddrPort(b []byte) (int, netip.AddrPort, error) {
+	select {
+	case <-c.done:
+		return 0, netip.AddrPort{}, net.ErrClosed
+	default:
+	}
+	select {
+	case d := <-c.in:
+		return copy(b, d.b), d.addr, nil
+	case <-c.done:
+		return 0, netip.AddrPort{}, net.ErrClosed
+	}
+}
+
+func (c *memConn) WriteToUDPAddrPort(b []byte, addr netip.AddrPort) (int, error) {
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	default:
+	}
+	c.m.send(b, c.addr, addr)
+	return len(b), nil
+}
+
+// Close removes c from its MemTransport, freeing its address.
+func (c *memConn) Close() error {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	if c.m.conns[c.addr] != c {
+		return net.ErrClosed
+	}
+	delete(c.m.conns, c.addr)
+	close(c.done)
+	return nil
+}
+
+func (c *memConn) LocalAddr() net.Addr {
+	return net.UDPAddrFromAddrPort(c.addr)
+}
@@ -0,0 +1,83 @@
+package swim
+
+import (
+	"context"
+	"math"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestMemTransport(t *testing.T) {
+	m := NewMemTransport()
+	m.SetLatency(time.Millisecond)
+	host := netip.MustParseAddrPort("127.0.0.1:0")
+	if _, err := m.Listen(netip.MustParseAddrPort("0.0.0.0:1000")); err == nil {
+		t.Error("Listen on unspecified address: got nil error")
+	}
+
+	const size = 5
+	nodes := make([]*Node, size)
+	for i := range nodes {
+		tr, err := m.Listen(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := StartTransport(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer n.Shutdown()
+		nodes[i] = n
+	}
+	if _, err := m.Listen(nodes[0].LocalAddr()); err == nil {
+		t.Error("Listen on address in use: got nil error")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// Each Node joins every earlier one directly, so that membership does
+	// not depend on dissemination through a single seed
+	for i, n := range nodes {
+		for _, peer := range nodes[:i] {
+			if err := n.JoinAndWait(ctx, peer.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, n := range nodes {
+		for len(n.Members()) < size {
+			select {
+			case <-ctx.Done():
+				t.Fatalf("node %v has %v members; expected %v", n.ID(), len(n.Members()), size)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	// A Node's address is freed when it shuts down
+	addr := nodes[size-1].LocalAddr()
+	nodes[size-1].Shutdown()
+	tr, err := m.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Close()
+}
+
+func TestSetLoss(t *testing.T) {
+	m := NewMemTransport()
+	for _, p := range []float64{0, 0.5, 1} {
+		m.SetLoss(p)
+	}
+	for _, p := range []float64{-0.1, 1.1, math.NaN()} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("SetLoss(%v) did not panic", p)
+				}
+			}()
+			m.SetLoss(p)
+		}()
+	}
+}
@@ -0,0 +1,76 @@
+package swim
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// An UpdateKind describes the event an Update reports.
+type UpdateKind byte
+
+const (
+	JoinUpdate UpdateKind = iota // a peer joined the network
+	MemoUpdate                   // the Node received a memo from a peer
+	FailUpdate                   // a peer left the network
+)
+
+func (k UpdateKind) String() string {
+	switch k {
+	case JoinUpdate:
+		return "join"
+	case MemoUpdate:
+		return "memo"
+	case FailUpdate:
+		return "fail"
+	}
+	return fmt.Sprintf("UpdateKind(%d)", byte(k))
+}
+
+// An Update reports a change in a Node's view of the network.
+type Update struct {
+	Kind   UpdateKind
+	NodeID string         // the peer the update concerns
+	Addr   netip.AddrPort // the peer's address, as last known for a FailUpdate
+
+	// Incarnation is the peer's incarnation number as known to the Node
+	// when the update occurred. It increases each time the peer refutes
+	// suspicion of its failure.
+	Incarnation int
+
+	Memo   []byte     // the memo received, for a MemoUpdate
+	Reason FailReason // why the peer left, for a FailUpdate
+}
+
+// OnUpdate registers f as an update handler, to be called for each peer that
+// joins the network, each memo n receives, and each peer that leaves. For
+// each peer, calls to f happen in that order: a memo update is not reported
+// until calls reporting the peer's join have returned, and a fail update not
+// until calls reporting its join and memos have returned. Handlers are
+// called in the order in which they were registered. OnJoin, OnMemo, OnFail,
+// and OnFailReason register handlers for individual kinds of update. OnUpdate
+// returns a function that unregisters f.
+func (n *Node) OnUpdate(f func(u Update)) (unregister func()) {
+	return addHandler(&n.mu, &n.updateHandlers, f)
+}
+
+// reportUpdate returns a function that passes u to the update handlers
+// registered with n at the time of the call to reportUpdate. The caller must
+// hold n.mu.
+func (n *Node) reportUpdate(u Update) func() {
+	hs := n.updateHandlers
+	return func() {
+		for _, h := range hs {
+			(*h)(u)
+		}
+	}
+}
+
+// update returns an Update of the given kind concerning the member id at
+// addr. The caller must hold n.mu.
+func (n *Node) update(kind UpdateKind, id id, addr netip.AddrPort) Update {
+	u := Update{Kind: kind, NodeID: string(id), Addr: addr}
+	if p, ok := n.fsm.members[id]; ok {
+		u.Incarnation = p.incarnation
+	}
+	return u
+}